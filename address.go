@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// resolveAddresses sets the bind address and the public host of the config.
+//
+// The bind address is bindAddress, or the deprecated host option, empty listens on all interfaces.
+// The public host is publicHost, the host option, the ip cvar of the game server,
+// the bind address if it is not a wildcard, or an address discovered from the network interfaces.
+// An error means no public host was found, sv_downloadurl can not be set unless customDownloadURL is set.
+func resolveAddresses(cfg *Config, gameIP string) error {
+	if cfg.BindAddress == "" {
+		cfg.BindAddress = cfg.Host
+	}
+
+	if cfg.PublicHost == "" {
+		cfg.PublicHost = cfg.Host
+	}
+
+	if cfg.PublicHost == "" && isSpecificAddress(gameIP) {
+		cfg.PublicHost = gameIP
+	}
+
+	if cfg.PublicHost == "" && isSpecificAddress(cfg.BindAddress) {
+		cfg.PublicHost = cfg.BindAddress
+	}
+
+	if cfg.PublicHost != "" || cfg.CustomDownloadURL != "" {
+		return nil
+	}
+
+	addr, err := discoverPublicAddress()
+	if err != nil {
+		return err
+	}
+
+	cfg.PublicHost = addr.String()
+
+	return nil
+}
+
+// isSpecificAddress reports whether the address is set and is not a wildcard like 0.0.0.0.
+func isSpecificAddress(host string) bool {
+	if host == "" {
+		return false
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		// Host names are specific.
+		return true
+	}
+
+	return !addr.IsUnspecified()
+}
+
+// discoverPublicAddress returns an IPv4 address of the network interfaces clients can connect to.
+// Public addresses are preferred over private ones, loopback and link-local addresses are skipped.
+func discoverPublicAddress() (netip.Addr, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return netip.Addr{}, errors.Wrap(err, "failed to list network interfaces")
+	}
+
+	var private netip.Addr
+
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, a := range addrs {
+			prefix, err := netip.ParsePrefix(a.String())
+			if err != nil {
+				continue
+			}
+
+			addr := prefix.Addr().Unmap()
+			if !addr.Is4() || !addr.IsGlobalUnicast() {
+				continue
+			}
+
+			if !addr.IsPrivate() {
+				return addr, nil
+			}
+
+			if !private.IsValid() {
+				private = addr
+			}
+		}
+	}
+
+	if private.IsValid() {
+		return private, nil
+	}
+
+	return netip.Addr{}, errors.New("no usable address found on network interfaces, set publicHost or customDownloadURL")
+}
+
+// listenAddress returns the address for net.Listen.
+func listenAddress(bindAddress string, port uint16) string {
+	return net.JoinHostPort(bindAddress, strconv.Itoa(int(port)))
+}
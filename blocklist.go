@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	blockListFilePrefix   = "file:"
+	blockListPollInterval = 10 * time.Second
+)
+
+type ipBlocker interface {
+	Blocked(addr netip.Addr) bool
+}
+
+// BlockList combines IPs listed in the config with IPs loaded from external files.
+// Files are polled for changes and reloaded without restarting the server.
+type BlockList struct {
+	static  []IPRange
+	sources []*blockListSource
+
+	mu  sync.Mutex
+	set atomic.Pointer[ipFilterSet]
+}
+
+type blockListSource struct {
+	path    string
+	modTime time.Time
+	size    int64
+	ranges  []IPRange
+
+	// Engine filters from listip.cfg with wildcards before fixed octets.
+	filters []ipFilter
+}
+
+// NewBlockList builds a block list from blockListIP config entries.
+// Entries prefixed with "file:" reference files, relative paths are resolved against baseDir.
+func NewBlockList(baseDir string, entries []string) *BlockList {
+	bl := &BlockList{}
+
+	for _, entry := range entries {
+		if path, ok := strings.CutPrefix(entry, blockListFilePrefix); ok {
+			path = strings.TrimSpace(path)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDir, path)
+			}
+
+			bl.sources = append(bl.sources, &blockListSource{path: path})
+
+			continue
+		}
+
+		r, err := ParseIPRange(entry)
+		if err != nil {
+			slog.Error("Failed to parse blocked IP", "item", entry, "error", err)
+
+			continue
+		}
+
+		bl.static = append(bl.static, r)
+	}
+
+	bl.Reload(true)
+
+	return bl
+}
+
+func (bl *BlockList) Blocked(addr netip.Addr) bool {
+	return bl.set.Load().Contains(addr)
+}
+
+// Empty reports whether the block list has neither entries nor sources.
+func (bl *BlockList) Empty() bool {
+	return len(bl.static) == 0 && len(bl.sources) == 0
+}
+
+// Reload rereads changed sources and rebuilds the set. With force, all sources are reread.
+func (bl *BlockList) Reload(force bool) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	changed := force
+
+	for _, source := range bl.sources {
+		updated, err := source.reload(force)
+		if err != nil {
+			slog.Error("Failed to load IP block list", "source", source.path, "error", err)
+
+			continue
+		}
+
+		changed = changed || updated
+	}
+
+	if !changed {
+		return
+	}
+
+	ranges := make([]IPRange, 0, len(bl.static))
+	ranges = append(ranges, bl.static...)
+
+	var filters []ipFilter
+
+	for _, source := range bl.sources {
+		ranges = append(ranges, source.ranges...)
+		filters = append(filters, source.filters...)
+	}
+
+	bl.set.Store(newIPFilterSet(ranges, filters))
+}
+
+// Watch polls sources for changes until ctx is done.
+func (bl *BlockList) Watch(ctx context.Context) {
+	if len(bl.sources) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(blockListPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			bl.Reload(false)
+		}
+	}
+}
+
+func (s *blockListSource) reload(force bool) (bool, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if s.ranges != nil || s.filters != nil {
+			s.ranges = nil
+			s.filters = nil
+			s.modTime = time.Time{}
+
+			return true, errors.WithMessage(err, "failed to stat file, entries are dropped")
+		}
+
+		return false, errors.WithMessage(err, "failed to stat file")
+	}
+
+	if !force && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return false, nil
+	}
+
+	contents, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, errors.WithMessage(err, "failed to read file")
+	}
+
+	ranges, filters, parseErrors := parseBlockListFile(contents)
+
+	for _, parseErr := range parseErrors {
+		slog.Warn("Failed to parse IP block list line", "source", s.path, "error", parseErr)
+	}
+
+	s.ranges = ranges
+	s.filters = filters
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+
+	slog.Info("IP block list loaded",
+		"source", s.path,
+		"entries", len(ranges)+len(filters),
+		"errors", len(parseErrors),
+	)
+
+	return true, nil
+}
+
+// parseBlockListFile parses one entry per line. Supported formats:
+//   - plain IP, CIDR or "a-b" range
+//   - P2P "name:start-end"
+//   - HLDS listip.cfg "addip <minutes> <ip>"
+//
+// Empty lines and lines starting with "#", ";" or "//" are skipped.
+// listip.cfg filters that are not a range, like 10.0.5.1 matching 10.*.5.1, are returned separately.
+func parseBlockListFile(contents []byte) ([]IPRange, []ipFilter, []error) {
+	var ranges []IPRange
+	var filters []ipFilter
+	var errs []error
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" ||
+			strings.HasPrefix(line, "#") ||
+			strings.HasPrefix(line, ";") ||
+			strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		if fields := strings.Fields(line); len(fields) == 3 && strings.EqualFold(fields[0], "addip") {
+			f, err := parseIPFilter(fields[2])
+			if err != nil {
+				errs = append(errs, errors.WithMessagef(err, "line %d", lineNumber))
+
+				continue
+			}
+
+			if r, ok := f.Range(); ok {
+				ranges = append(ranges, r)
+			} else {
+				filters = append(filters, f)
+			}
+
+			continue
+		}
+
+		r, err := parseBlockListLine(line)
+		if err != nil {
+			errs = append(errs, errors.WithMessagef(err, "line %d", lineNumber))
+
+			continue
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return ranges, filters, errs
+}
+
+func parseBlockListLine(line string) (IPRange, error) {
+	r, err := ParseIPRange(line)
+	if err == nil {
+		return r, nil
+	}
+
+	// P2P format, the name may contain colons, the IPv4 range can not.
+	if i := strings.LastIndex(line, ":"); i >= 0 {
+		if p2p, p2pErr := ParseIPRange(line[i+1:]); p2pErr == nil && p2p.From.Is4() {
+			return p2p, nil
+		}
+	}
+
+	return IPRange{}, err
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// caseIndex maps case-folded names of the served tree to the names on disk.
+// Content made on Windows is often precached with a different case than the files have on Linux.
+type caseIndex struct {
+	names map[string]string
+
+	// Names differing only in case, keyed by the folded name.
+	collisions map[string][]string
+}
+
+// newCaseIndex walks the file system and indexes all files and directories.
+func newCaseIndex(fsys fs.FS) (*caseIndex, error) {
+	index := &caseIndex{
+		names:      make(map[string]string),
+		collisions: make(map[string][]string),
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable entries, they can not be served either.
+			return nil
+		}
+
+		if name == "." {
+			return nil
+		}
+
+		folded := foldName(name)
+
+		existing, ok := index.names[folded]
+		if !ok {
+			index.names[folded] = name
+
+			return nil
+		}
+
+		if len(index.collisions[folded]) == 0 {
+			index.collisions[folded] = []string{existing}
+		}
+
+		index.collisions[folded] = append(index.collisions[folded], name)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// Lookup returns the name on disk for the name in any case.
+// For names differing only in case the first one in the walk order is returned.
+func (i *caseIndex) Lookup(name string) (string, bool) {
+	if i == nil {
+		return "", false
+	}
+
+	actual, ok := i.names[foldName(name)]
+
+	return actual, ok
+}
+
+// Collisions returns groups of names differing only in case, sorted by name.
+func (i *caseIndex) Collisions() [][]string {
+	if i == nil {
+		return nil
+	}
+
+	groups := make([][]string, 0, len(i.collisions))
+	for _, names := range i.collisions {
+		groups = append(groups, names)
+	}
+
+	slices.SortFunc(groups, func(a, b []string) int {
+		return strings.Compare(a[0], b[0])
+	})
+
+	return groups
+}
+
+// foldName lowers ASCII letters only, like the engine compares file names.
+// Other bytes are kept as is, so names in legacy code pages do not collapse to U+FFFD.
+func foldName(name string) string {
+	for i := 0; i < len(name); i++ {
+		if 'A' <= name[i] && name[i] <= 'Z' {
+			return foldASCII(name, i)
+		}
+	}
+
+	return name
+}
+
+func foldASCII(name string, from int) string {
+	b := []byte(name)
+
+	for i := from; i < len(b); i++ {
+		if 'A' <= b[i] && b[i] <= 'Z' {
+			b[i] += 'a' - 'A'
+		}
+	}
+
+	return string(b)
+}
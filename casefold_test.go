@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func mustEncodeCP1251(t *testing.T, name string) string {
+	t.Helper()
+
+	encoded, err := charmap.Windows1251.NewEncoder().String(name)
+	if err != nil {
+		t.Fatalf("failed to encode %q: %v", name, err)
+	}
+
+	return encoded
+}
+
+func TestFoldName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "sound/a.wav", want: "sound/a.wav"},
+		{name: "Models/Player/Foo.MDL", want: "models/player/foo.mdl"},
+		{name: "sound/Музыка.WAV", want: "sound/Музыка.wav"},
+		{name: "sound/\xcc\xf3\xe7\xfb\xea\xe0.WAV", want: "sound/\xcc\xf3\xe7\xfb\xea\xe0.wav"},
+	}
+
+	for _, tt := range tests {
+		if got := foldName(tt.name); got != tt.want {
+			t.Errorf("foldName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCaseIndexLegacyNames(t *testing.T) {
+	gameDir := t.TempDir()
+	music := mustEncodeCP1251(t, "музыка.wav")
+
+	if err := os.MkdirAll(filepath.Join(gameDir, "sound"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(gameDir, "sound", music), []byte("music"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.FilenameEncodings = []string{"cp1251"}
+
+	h, err := newFileHandler(gameDir, NewPlugin(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer h.fs.Close()
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/sound/музыка.wav", want: http.StatusOK},
+		{path: "/SOUND/музыка.WAV", want: http.StatusOK},
+		{path: "/sound/" + music, want: http.StatusOK},
+		{path: "/sound/привет.wav", want: http.StatusNotFound},
+		{path: "/sound/" + mustEncodeCP1251(t, "привет.wav"), want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL = &url.URL{Path: tt.path}
+
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.want {
+			t.Errorf("GET %q = %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
+
+func TestPrecachedLegacyNames(t *testing.T) {
+	p := NewPlugin()
+	p.AppendPrecached("sound/" + mustEncodeCP1251(t, "музыка.wav"))
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "sound/" + mustEncodeCP1251(t, "музыка.wav"), want: true},
+		{name: "SOUND/" + mustEncodeCP1251(t, "музыка.WAV"), want: true},
+		{name: "sound/" + mustEncodeCP1251(t, "привет.wav"), want: false},
+		{name: "sound/" + mustEncodeCP1251(t, "ЗВУКИ_.wav"), want: false},
+	}
+
+	for _, tt := range tests {
+		if got := p.IsPrecached(tt.name); got != tt.want {
+			t.Errorf("IsPrecached(%q) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrecachedConcurrentAccess(t *testing.T) {
+	p := NewPlugin()
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		for i := range 1000 {
+			p.AppendPrecached(fmt.Sprintf("sound/%d.wav", i))
+
+			if i%100 == 0 {
+				_ = p.Reset()
+			}
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		for i := range 1000 {
+			p.IsPrecached(fmt.Sprintf("sound/%d.wav", i))
+		}
+	}()
+
+	wg.Wait()
+
+	if !p.IsPrecached("sound/999.wav") {
+		t.Error("sound/999.wav is not precached")
+	}
+}
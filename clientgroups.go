@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+type clientGroupsContextKey struct{}
+
+// ClientGroups are named groups of clients defined by IP addresses.
+type ClientGroups struct {
+	groups  []*clientGroup
+	private map[string]struct{}
+}
+
+type clientGroup struct {
+	name   string
+	ips    *IPRangeSet
+	config ConfigClientGroup
+}
+
+// ClientMembership is a set of groups the client belongs to.
+type ClientMembership []*clientGroup
+
+func NewClientGroups(cfg map[string]ConfigClientGroup, privateGroups []string) *ClientGroups {
+	cg := &ClientGroups{
+		groups:  make([]*clientGroup, 0, len(cfg)),
+		private: make(map[string]struct{}, len(privateGroups)),
+	}
+
+	for name, groupCfg := range cfg {
+		ranges := make([]IPRange, 0, len(groupCfg.IPs))
+
+		for _, item := range groupCfg.IPs {
+			r, err := ParseIPRange(item)
+			if err != nil {
+				slog.Error("Failed to parse client group IP", "group", name, "item", item, "error", err)
+
+				continue
+			}
+
+			ranges = append(ranges, r)
+		}
+
+		cg.groups = append(cg.groups, &clientGroup{
+			name:   name,
+			ips:    NewIPRangeSet(ranges),
+			config: groupCfg,
+		})
+	}
+
+	slices.SortFunc(cg.groups, func(a, b *clientGroup) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	for _, name := range privateGroups {
+		if _, ok := cfg[name]; !ok {
+			slog.Warn("Unknown private client group", "group", name)
+		}
+
+		cg.private[name] = struct{}{}
+	}
+
+	return cg
+}
+
+// Empty reports whether there are neither groups nor private mode.
+func (cg *ClientGroups) Empty() bool {
+	return len(cg.groups) == 0 && len(cg.private) == 0
+}
+
+// Membership returns groups containing the address.
+func (cg *ClientGroups) Membership(addr netip.Addr) ClientMembership {
+	var membership ClientMembership
+
+	for _, group := range cg.groups {
+		if group.ips.Contains(addr) {
+			membership = append(membership, group)
+		}
+	}
+
+	return membership
+}
+
+// Allowed reports whether the client may access the server in private mode.
+func (cg *ClientGroups) Allowed(membership ClientMembership) bool {
+	if len(cg.private) == 0 {
+		return true
+	}
+
+	for _, group := range membership {
+		if _, ok := cg.private[group.name]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m ClientMembership) BypassRateLimits() bool {
+	return slices.ContainsFunc(m, func(group *clientGroup) bool {
+		return group.config.BypassRateLimits
+	})
+}
+
+func (m ClientMembership) AutoIndex() bool {
+	return slices.ContainsFunc(m, func(group *clientGroup) bool {
+		return group.config.AutoIndex
+	})
+}
+
+func (m ClientMembership) Admin() bool {
+	return slices.ContainsFunc(m, func(group *clientGroup) bool {
+		return group.config.Admin
+	})
+}
+
+func withClientMembership(ctx context.Context, membership ClientMembership) context.Context {
+	return context.WithValue(ctx, clientGroupsContextKey{}, membership)
+}
+
+func clientMembershipFromContext(ctx context.Context) ClientMembership {
+	membership, _ := ctx.Value(clientGroupsContextKey{}).(ClientMembership)
+
+	return membership
+}
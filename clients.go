@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const defaultConnectedClientsTTL = 10 * time.Minute
+
+// ClientTracker keeps IP addresses of clients connecting or connected to the game server.
+type ClientTracker struct {
+	mu        sync.RWMutex
+	byIndex   map[int]netip.Addr
+	connected map[netip.Addr]int // number of connections from the address
+	lastSeen  map[netip.Addr]time.Time
+}
+
+func NewClientTracker() *ClientTracker {
+	return &ClientTracker{
+		byIndex:   make(map[int]netip.Addr),
+		connected: make(map[netip.Addr]int),
+		lastSeen:  make(map[netip.Addr]time.Time),
+	}
+}
+
+// Connect registers a client by its edict index and address in the "ip:port" form.
+func (t *ClientTracker) Connect(index int, address string) bool {
+	addr, ok := parseClientAddress(address)
+	if !ok {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if prev, exists := t.byIndex[index]; exists {
+		t.release(prev)
+	}
+
+	t.byIndex[index] = addr
+	t.connected[addr]++
+	t.lastSeen[addr] = time.Now()
+
+	return true
+}
+
+func (t *ClientTracker) Disconnect(index int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	addr, ok := t.byIndex[index]
+	if !ok {
+		return
+	}
+
+	delete(t.byIndex, index)
+	t.release(addr)
+}
+
+// Seen reports whether the address is connected or was seen within ttl.
+func (t *ClientTracker) Seen(addr netip.Addr, ttl time.Duration) bool {
+	addr = unmapAddr(addr)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.connected[addr] > 0 {
+		return true
+	}
+
+	lastSeen, ok := t.lastSeen[addr]
+
+	return ok && time.Since(lastSeen) <= ttl
+}
+
+// Cleanup forgets disconnected addresses not seen within ttl.
+func (t *ClientTracker) Cleanup(ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for addr, lastSeen := range t.lastSeen {
+		if t.connected[addr] == 0 && time.Since(lastSeen) > ttl {
+			delete(t.lastSeen, addr)
+		}
+	}
+}
+
+func (t *ClientTracker) release(addr netip.Addr) {
+	t.lastSeen[addr] = time.Now()
+
+	t.connected[addr]--
+	if t.connected[addr] <= 0 {
+		delete(t.connected, addr)
+	}
+}
+
+func parseClientAddress(address string) (netip.Addr, bool) {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err == nil {
+		return unmapAddr(addrPort.Addr()), true
+	}
+
+	addr, err := netip.ParseAddr(address)
+	if err == nil {
+		return unmapAddr(addr), true
+	}
+
+	// Listen server host and bots have "loopback" or empty address.
+	return netip.Addr{}, false
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	metamod "github.com/et-nik/metamod-go"
+)
+
+func registerServerCommands(engineFuncs *metamod.EngineFuncs, p *Plugin) {
+	engineFuncs.AddServerCommand("fastdl_bans", func(argc int, argv ...string) {
+		bans := p.autoBans.List()
+
+		if len(bans) == 0 {
+			engineFuncs.ServerPrint("FastDL: no active bans\n")
+
+			return
+		}
+
+		engineFuncs.ServerPrintf("FastDL: %d active bans\n", len(bans))
+
+		for _, ban := range bans {
+			engineFuncs.ServerPrintf(
+				"  %-40s %-12s %s\n",
+				ban.Addr.String(),
+				time.Until(ban.Expires).Round(time.Second).String(),
+				ban.Reason,
+			)
+		}
+	})
+
+	engineFuncs.AddServerCommand("fastdl_unban", func(argc int, argv ...string) {
+		if argc < 2 {
+			engineFuncs.ServerPrint("Usage: fastdl_unban <ip>\n")
+
+			return
+		}
+
+		addr, err := netip.ParseAddr(argv[1])
+		if err != nil {
+			engineFuncs.ServerPrintf("FastDL: invalid IP %q\n", argv[1])
+
+			return
+		}
+
+		if !p.autoBans.Unban(addr) {
+			engineFuncs.ServerPrintf("FastDL: %s is not banned\n", addr)
+
+			return
+		}
+
+		engineFuncs.ServerPrintf("FastDL: %s unbanned\n", addr)
+	})
+
+	engineFuncs.AddServerCommand("fastdl_explain", func(argc int, argv ...string) {
+		if argc < 2 {
+			engineFuncs.ServerPrint("Usage: fastdl_explain <path>\n")
+
+			return
+		}
+
+		handler := p.FileHandler()
+		if handler == nil {
+			engineFuncs.ServerPrint("FastDL: server is not running or disabled\n")
+
+			return
+		}
+
+		requestedPath := path.Clean("/" + handler.resolveRequest(argv[1]))
+
+		info, err := handler.fs.Stat(gameFSName(requestedPath))
+
+		switch {
+		case err != nil:
+			engineFuncs.ServerPrintf("FastDL: %s does not exist, file rules:\n", requestedPath)
+			engineFuncs.ServerPrintf("  %s\n", handler.fileDecision(requestedPath))
+		case info.IsDir():
+			engineFuncs.ServerPrintf("FastDL: %s is a directory\n", requestedPath)
+			engineFuncs.ServerPrintf("  %s\n", handler.pathDecision(requestedPath))
+
+			if !handler.Config().AutoIndexEnabled {
+				engineFuncs.ServerPrint("  auto index is disabled\n")
+			}
+		default:
+			dir, _ := handler.fs.Locate(gameFSName(requestedPath))
+
+			engineFuncs.ServerPrintf("FastDL: %s is a file in %s\n", requestedPath, dir)
+			engineFuncs.ServerPrintf("  %s\n", handler.fileDecision(requestedPath))
+		}
+	})
+
+	engineFuncs.AddServerCommand("fastdl_exposure", func(argc int, argv ...string) {
+		handler := p.FileHandler()
+		if handler == nil {
+			engineFuncs.ServerPrint("FastDL: server is not running or disabled\n")
+
+			return
+		}
+
+		engineFuncs.ServerPrint("FastDL: building exposure report...\n")
+
+		// The report walks the served tree, it is printed on a later frame.
+		go func() {
+			report, err := BuildExposureReport(handler, handler.Config().ExposureLargeFileSize.Int64())
+			if err != nil {
+				p.console.Printf("FastDL: failed to build exposure report: %s\n", err)
+
+				return
+			}
+
+			err = report.WriteText(&p.console)
+			if err != nil {
+				p.console.Printf("FastDL: failed to print exposure report: %s\n", err)
+			}
+		}()
+	})
+
+	engineFuncs.AddServerCommand("fastdl_case_collisions", func(argc int, argv ...string) {
+		handler := p.FileHandler()
+		if handler == nil {
+			engineFuncs.ServerPrint("FastDL: server is not running or disabled\n")
+
+			return
+		}
+
+		if handler.caseIndex == nil {
+			engineFuncs.ServerPrint("FastDL: case-insensitive resolution is disabled\n")
+
+			return
+		}
+
+		collisions := handler.caseIndex.Collisions()
+		if len(collisions) == 0 {
+			engineFuncs.ServerPrint("FastDL: no files differing only in case\n")
+
+			return
+		}
+
+		engineFuncs.ServerPrintf("FastDL: %d names differ only in case, the first one is served:\n", len(collisions))
+
+		for _, names := range collisions {
+			engineFuncs.ServerPrintf("  %s\n", strings.Join(names, ", "))
+		}
+	})
+
+	engineFuncs.AddServerCommand("fastdl_config_check", func(argc int, argv ...string) {
+		file := findConfigFile(p.GameDir())
+		if file == "" {
+			engineFuncs.ServerPrint("FastDL: config file not found, using defaults\n")
+		}
+
+		_, issues, err := checkConfigFile(p.GameDir(), file)
+		if err != nil {
+			engineFuncs.ServerPrintf("FastDL: %s\n", err)
+
+			return
+		}
+
+		for _, issue := range issues {
+			engineFuncs.ServerPrintf("  %s\n", issue)
+		}
+
+		if len(issues) == 0 {
+			engineFuncs.ServerPrint("FastDL: config is valid\n")
+
+			return
+		}
+
+		engineFuncs.ServerPrintf(
+			"FastDL: config has %d errors and %d warnings\n",
+			issues.Count(configSeverityError),
+			issues.Count(configSeverityWarning),
+		)
+	})
+
+	engineFuncs.AddServerCommand("fastdl_reload", func(argc int, argv ...string) {
+		engineFuncs.ServerPrint("FastDL: reloading config...\n")
+
+		// Reload walks the game files, the result is printed on a later frame.
+		go func() {
+			if err := p.Reload(); err != nil {
+				p.console.Printf("FastDL: failed to reload config: %s\n", err)
+
+				return
+			}
+
+			p.console.Printf("FastDL: config reloaded\n")
+		}()
+	})
+}
+
+// consoleQueue collects messages of background tasks for the server console.
+// The engine functions can only be called on the game thread, the messages are printed by Flush on StartFrame.
+type consoleQueue struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (q *consoleQueue) Printf(format string, args ...any) {
+	q.mu.Lock()
+	q.messages = append(q.messages, fmt.Sprintf(format, args...))
+	q.mu.Unlock()
+}
+
+func (q *consoleQueue) Write(p []byte) (int, error) {
+	q.mu.Lock()
+	q.messages = append(q.messages, string(p))
+	q.mu.Unlock()
+
+	return len(p), nil
+}
+
+// Flush prints the queued messages, it must be called on the game thread.
+func (q *consoleQueue) Flush(engineFuncs *metamod.EngineFuncs) {
+	q.mu.Lock()
+	messages := q.messages
+	q.messages = nil
+	q.mu.Unlock()
+
+	for _, message := range messages {
+		engineFuncs.ServerPrint(message)
+	}
+}
@@ -1,58 +1,179 @@
 package main
 
 import (
+	"fmt"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
+	"log/slog"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type Config struct {
-	Host                string            `yaml:"host"`
-	Port                uint16            `yaml:"port"`
-	PortRange           ConfigPortRange   `yaml:"portRange"`
-	AutoIndexEnabled    bool              `yaml:"autoIndexEnabled"`
-	ServePrecached      bool              `yaml:"servePrecached"`
-	ForbiddenRegexp     []string          `yaml:"forbiddenRegexp"`
-	ForbiddenExtensions []string          `yaml:"forbiddenExtensions"`
-	AllowedExtensions   []string          `yaml:"allowedExtensions"`
-	ForbiddenPaths      []string          `yaml:"forbiddenPaths"`
-	AllowedPaths        []string          `yaml:"allowedPaths"`
-	CacheSize           ConfigCacheSize   `yaml:"cacheSize"`
-	CustomDownloadURL   string            `yaml:"customDownloadURL"`
-	HTTP                ConfigHTTP        `yaml:"http"`
-	RateLimits          []ConfigRateLimit `yaml:"rateLimits"`
-	BlockListIP         []string          `yaml:"blockListIP"`
+	Enabled             bool                        `yaml:"enabled"`
+	BindAddress         string                      `yaml:"bindAddress"`
+	PublicHost          string                      `yaml:"publicHost"`
+	Host                string                      `yaml:"host"`
+	Port                uint16                      `yaml:"port"`
+	PortRange           ConfigPortRange             `yaml:"portRange"`
+	AutoIndexEnabled    bool                        `yaml:"autoIndexEnabled"`
+	ServePrecached      bool                        `yaml:"servePrecached"`
+	ForbiddenRegexp     ConfigList[string]          `yaml:"forbiddenRegexp"`
+	ForbiddenExtensions ConfigList[string]          `yaml:"forbiddenExtensions"`
+	AllowedExtensions   ConfigList[string]          `yaml:"allowedExtensions"`
+	ForbiddenPaths      ConfigList[string]          `yaml:"forbiddenPaths"`
+	AllowedPaths        ConfigList[string]          `yaml:"allowedPaths"`
+	CacheSize           ConfigCacheSize             `yaml:"cacheSize"`
+	CustomDownloadURL   string                      `yaml:"customDownloadURL"`
+	HTTP                ConfigHTTP                  `yaml:"http"`
+	RateLimits          ConfigList[ConfigRateLimit] `yaml:"rateLimits"`
+	BlockListIP         ConfigList[string]          `yaml:"blockListIP"`
+	ShareGameBans       bool                        `yaml:"shareGameBans"`
+
+	IntrusionDetection ConfigIntrusionDetection `yaml:"intrusionDetection"`
+
+	ClientGroups  map[string]ConfigClientGroup `yaml:"clientGroups"`
+	PrivateGroups ConfigList[string]           `yaml:"privateGroups"`
+
+	OnlyConnectedClients bool          `yaml:"onlyConnectedClients"`
+	ConnectedClientsTTL  ConfigTimeout `yaml:"connectedClientsTTL"`
+
+	GeoIP ConfigGeoIP `yaml:"geoIP"`
+
+	Rules             ConfigList[string] `yaml:"rules"`
+	RulesMatch        string             `yaml:"rulesMatch"`
+	BaselineOverrides ConfigList[string] `yaml:"baselineOverrides"`
+
+	Debug bool `yaml:"debug"`
+
+	ExposureLargeFileSize ConfigCacheSize `yaml:"exposureLargeFileSize"`
+
+	SecretGuard ConfigSecretGuard `yaml:"secretGuard"`
+
+	Symlinks          string             `yaml:"symlinks"`
+	SearchPaths       ConfigList[string] `yaml:"searchPaths"`
+	CaseInsensitive   bool               `yaml:"caseInsensitive"`
+	FilenameEncodings ConfigList[string] `yaml:"filenameEncodings"`
+
+	Profile      string             `yaml:"profile"`
+	MapResources ConfigList[string] `yaml:"mapResources"`
+
+	Maps ConfigMaps `yaml:"maps"`
+
+	// Extra resources of the current map from the maps sections.
+	mapResources []string
+}
+
+type ConfigSecretGuard struct {
+	Enabled  bool               `yaml:"enabled"`
+	Patterns ConfigList[string] `yaml:"patterns"`
+}
+
+type ConfigGeoIP struct {
+	Databases     ConfigList[string]          `yaml:"databases"`
+	Rules         ConfigList[ConfigGeoIPRule] `yaml:"rules"`
+	DefaultAction string                      `yaml:"defaultAction"`
+}
+
+type ConfigGeoIPRule struct {
+	Countries []string      `yaml:"countries"`
+	ASNs      []uint        `yaml:"asns"`
+	Action    string        `yaml:"action"`
+	Limit     int           `yaml:"limit"`
+	Period    ConfigTimeout `yaml:"period"`
+}
+
+type ConfigClientGroup struct {
+	IPs              []string `yaml:"ips"`
+	BypassRateLimits bool     `yaml:"bypassRateLimits"`
+	AutoIndex        bool     `yaml:"autoIndex"`
+	Admin            bool     `yaml:"admin"`
 }
 
+type ConfigIntrusionDetection struct {
+	Enabled      bool                      `yaml:"enabled"`
+	Threshold    int                       `yaml:"threshold"`
+	Window       ConfigTimeout             `yaml:"window"`
+	BanDurations ConfigList[ConfigTimeout] `yaml:"banDurations"`
+}
+
+// defaultReadHeaderTimeout limits reading request headers if readTimeout is not set,
+// so slow clients can not hold connections open forever.
+const defaultReadHeaderTimeout = 10 * time.Second
+
 type ConfigHTTP struct {
 	ReadTimeout  ConfigTimeout `yaml:"readTimeout"`
 	WriteTimeout ConfigTimeout `yaml:"writeTimeout"`
 }
 
+// ReadHeaderTimeout returns the time allowed to read request headers, readTimeout or the default.
+func (c ConfigHTTP) ReadHeaderTimeout() time.Duration {
+	if d := c.ReadTimeout.Duration(); d > 0 {
+		return d
+	}
+
+	return defaultReadHeaderTimeout
+}
+
 type ConfigRateLimit struct {
-	period string `yaml:"period"`
-	limit  int    `yaml:"limit"`
+	Period ConfigTimeout `yaml:"period"`
+	Limit  int           `yaml:"limit"`
 }
 
-func (c ConfigRateLimit) Period() time.Duration {
-	d, err := time.ParseDuration(c.period)
-	if err != nil {
-		return 0
+// ConfigList is a list option merged onto the value from a lower config layer.
+// A plain YAML sequence replaces the list, a mapping with "replace" and/or "append" keys
+// replaces the list and then appends to it:
+//
+//	allowedExtensions:
+//	  append: [ogg]
+type ConfigList[T any] []T
+
+func (l *ConfigList[T]) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		var items []T
+
+		if err := value.Decode(&items); err != nil {
+			return err
+		}
+
+		*l = items
+
+		return nil
 	}
 
-	return d
-}
+	var ops struct {
+		Replace *[]T `yaml:"replace"`
+		Append  []T  `yaml:"append"`
+	}
+
+	for i := 0; i < len(value.Content); i += 2 {
+		key := value.Content[i].Value
+		if key != "replace" && key != "append" {
+			return errors.Errorf("line %d: unknown list operation %q, expected \"replace\" or \"append\"", value.Content[i].Line, key)
+		}
+	}
+
+	if err := value.Decode(&ops); err != nil {
+		return err
+	}
 
-func (c ConfigRateLimit) Limit() int {
-	return c.limit
+	items := slices.Clone(*l)
+	if ops.Replace != nil {
+		items = *ops.Replace
+	}
+
+	*l = append(items, ops.Append...)
+
+	return nil
 }
 
 type ConfigTimeout string
 
 func (c ConfigTimeout) Duration() time.Duration {
-	d, err := time.ParseDuration(string(c))
+	d, err := c.Parse()
 	if err != nil {
 		return 0
 	}
@@ -60,10 +181,40 @@ func (c ConfigTimeout) Duration() time.Duration {
 	return d
 }
 
+// Parse parses the duration, an empty value is zero.
+func (c ConfigTimeout) Parse() (time.Duration, error) {
+	if c == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(string(c))
+	if err != nil {
+		return 0, errors.Errorf("invalid duration %q, expected a value like 30s, 5m or 1h", string(c))
+	}
+
+	if d < 0 {
+		return 0, errors.Errorf("negative duration %q", string(c))
+	}
+
+	return d, nil
+}
+
 type ConfigCacheSize string
 
 func (c ConfigCacheSize) Int64() int64 {
+	size, err := c.Parse()
+	if err != nil {
+		return 0
+	}
+
+	return size
+}
+
+// Parse parses the size in bytes, an empty value is zero.
+func (c ConfigCacheSize) Parse() (int64, error) {
 	multipliers := map[string]int64{
+		"":   1,
+		"B":  1,
 		"KB": 1024,
 		"MB": 1024 * 1024,
 		"GB": 1024 * 1024 * 1024,
@@ -71,101 +222,172 @@ func (c ConfigCacheSize) Int64() int64 {
 	}
 
 	str := strings.TrimSpace(strings.ToUpper(string(c)))
+	if str == "" {
+		return 0, nil
+	}
 
-	var numberPart string
-	var unitPart string
+	numberPart := str
+	unitPart := ""
 	for i, r := range str {
 		if r < '0' || r > '9' {
 			numberPart = str[:i]
-			unitPart = str[i:]
+			unitPart = strings.TrimSpace(str[i:])
 			break
 		}
 	}
 
-	if numberPart == "" {
-		return 0
-	}
-
 	number, err := strconv.ParseInt(numberPart, 10, 64)
 	if err != nil {
-		return 0
+		return 0, errors.Errorf("invalid size %q, expected a value like 512KB, 100MB or 1GB", string(c))
 	}
 
 	multiplier, exists := multipliers[unitPart]
 	if !exists {
-		return number
+		return 0, errors.Errorf("invalid size unit %q in %q, expected B, KB, MB, GB or TB", unitPart, string(c))
 	}
 
-	return number * multiplier
+	return number * multiplier, nil
 }
 
 type ConfigPortRange string
 
 func (c ConfigPortRange) IntRange() (int, int) {
+	low, high, err := c.Parse()
+	if err != nil {
+		return 0, 0
+	}
+
+	return low, high
+}
+
+// Parse parses the "low-high" range, an empty value is zero.
+func (c ConfigPortRange) Parse() (int, int, error) {
+	if c == "" {
+		return 0, 0, nil
+	}
+
 	splitted := strings.SplitN(string(c), "-", 2)
 	if len(splitted) != 2 {
-		return 0, 0
+		return 0, 0, errors.Errorf("invalid port range %q, expected a value like 40000-50000", string(c))
 	}
 
-	low, err := strconv.Atoi(splitted[0])
+	low, err := strconv.Atoi(strings.TrimSpace(splitted[0]))
 	if err != nil {
-		return 0, 0
+		return 0, 0, errors.Errorf("invalid port range %q, expected a value like 40000-50000", string(c))
 	}
 
-	high, err := strconv.Atoi(splitted[1])
+	high, err := strconv.Atoi(strings.TrimSpace(splitted[1]))
 	if err != nil {
-		return 0, 0
+		return 0, 0, errors.Errorf("invalid port range %q, expected a value like 40000-50000", string(c))
 	}
 
-	return low, high
-}
+	if low < 1 || high > 65535 || low > high {
+		return 0, 0, errors.Errorf("invalid port range %q, ports must be within 1-65535 and low <= high", string(c))
+	}
 
-func ParseConfig(in []byte) (*Config, error) {
-	var cfg Config
+	return low, high, nil
+}
 
-	err := yaml.Unmarshal(in, &cfg)
+// ParseConfig parses the config file on top of the base config.
+func ParseConfig(base *Config, in []byte) (*Config, error) {
+	err := yaml.Unmarshal(in, base)
 	if err != nil {
 		return nil, errors.WithMessage(err, "failed to unmarshal config")
 	}
 
-	return &cfg, nil
-}
-
-var DefaultConfig = &Config{
-	Host:             "",
-	Port:             0,
-	AutoIndexEnabled: false,
-	ForbiddenRegexp: []string{
-		"mapcycle.*",
-		".*textscheme.*",
-	},
-	AllowedExtensions: []string{
-		"bmp",
-		"bsp",
-		"gif",
-		"jpeg",
-		"jpg",
-		"lmp",
-		"lst",
-		"mdl",
-		"mp3",
-		"png",
-		"res",
-		"spr",
-		"tga",
-		"txt",
-		"wad",
-		"wav",
-		"zip",
-	},
-	AllowedPaths: []string{
-		"gfx",
-		"maps",
-		"media",
-		"models",
-		"overviews",
-		"sound",
-		"sprites",
-	},
-	CacheSize: "100MB",
+	return base, nil
+}
+
+// BaseConfig returns the default config with the mod profile and overrides from FASTDL_* environment variables.
+func BaseConfig(gameDir, profile string) (*Config, ConfigIssues) {
+	var issues ConfigIssues
+
+	cfg := DefaultConfig()
+
+	mod, err := applyModProfile(cfg, gameDir, profile)
+	if err != nil {
+		issues = append(issues, ConfigIssue{
+			File:     envConfigFile,
+			Severity: configSeverityError,
+			Message:  "profile: " + errors.Cause(err).Error(),
+		})
+	} else if mod != "" {
+		slog.Debug("Mod profile applied", "profile", mod)
+	}
+
+	issues = append(issues, applyEnvironment(cfg, os.Environ())...)
+
+	return cfg, issues
+}
+
+// String returns the config as a single line of YAML.
+func (c *Config) String() string {
+	var node yaml.Node
+
+	if err := node.Encode(c); err != nil {
+		return fmt.Sprintf("%+v", *c)
+	}
+
+	node.Style = yaml.FlowStyle
+
+	out, err := yaml.Marshal(&node)
+	if err != nil {
+		return fmt.Sprintf("%+v", *c)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// DefaultConfig returns a new copy of the default config.
+func DefaultConfig() *Config {
+	return &Config{
+		Enabled:          true,
+		Host:             "",
+		Port:             0,
+		AutoIndexEnabled: false,
+		ForbiddenRegexp: []string{
+			"mapcycle.*",
+			".*textscheme.*",
+		},
+		AllowedExtensions: []string{
+			"bmp",
+			"bsp",
+			"gif",
+			"jpeg",
+			"jpg",
+			"lmp",
+			"lst",
+			"mdl",
+			"mp3",
+			"png",
+			"res",
+			"spr",
+			"tga",
+			"txt",
+			"wad",
+			"wav",
+			"zip",
+		},
+		AllowedPaths: []string{
+			"gfx",
+			"maps",
+			"media",
+			"models",
+			"overviews",
+			"sound",
+			"sprites",
+		},
+		MapResources: []string{
+			"maps/{map}_detail.txt",
+			"gfx/env/{sky}{side}.tga",
+			"gfx/env/{sky}{side}.bmp",
+		},
+		CacheSize:     "100MB",
+		ShareGameBans: true,
+		SecretGuard: ConfigSecretGuard{
+			Enabled:  true,
+			Patterns: slices.Clone(defaultSecretPatterns),
+		},
+		CaseInsensitive: true,
+	}
 }
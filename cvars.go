@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"strconv"
+	"time"
+
+	metamod "github.com/et-nik/metamod-go"
+	"github.com/pkg/errors"
+)
+
+const cvarPollInterval = time.Second
+
+// cvarOverride is a cvar overriding a config option. Empty cvars keep the config value.
+type cvarOverride struct {
+	name  string
+	apply func(cfg *Config, value string) error
+}
+
+var cvarOverrides = []cvarOverride{
+	{
+		name: "fastdl_enabled",
+		apply: func(cfg *Config, value string) error {
+			return parseCVarBool(value, &cfg.Enabled)
+		},
+	},
+	{
+		name: "fastdl_port",
+		apply: func(cfg *Config, value string) error {
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil || port == 0 {
+				return errors.Errorf("invalid port %q", value)
+			}
+
+			cfg.Port = uint16(port)
+
+			return nil
+		},
+	},
+	{
+		name: "fastdl_serve_precached",
+		apply: func(cfg *Config, value string) error {
+			return parseCVarBool(value, &cfg.ServePrecached)
+		},
+	},
+	{
+		name: "fastdl_autoindex",
+		apply: func(cfg *Config, value string) error {
+			return parseCVarBool(value, &cfg.AutoIndexEnabled)
+		},
+	},
+	{
+		name: "fastdl_cache_size",
+		apply: func(cfg *Config, value string) error {
+			if _, err := ConfigCacheSize(value).Parse(); err != nil {
+				return err
+			}
+
+			cfg.CacheSize = ConfigCacheSize(value)
+
+			return nil
+		},
+	},
+}
+
+func parseCVarBool(value string, out *bool) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return errors.Errorf("invalid boolean %q, expected 1 or 0", value)
+	}
+
+	*out = b
+
+	return nil
+}
+
+func registerCVars(engineFuncs *metamod.EngineFuncs) {
+	for _, override := range cvarOverrides {
+		engineFuncs.CVarRegister(metamod.NewCVar(override.name, "", metamod.CVarServer|metamod.CVarUnlogged))
+	}
+}
+
+// readCVars returns values of cvars that are set.
+func readCVars(engineFuncs *metamod.EngineFuncs) map[string]string {
+	values := make(map[string]string, len(cvarOverrides))
+
+	for _, override := range cvarOverrides {
+		if value := engineFuncs.CVarGetString(override.name); value != "" {
+			values[override.name] = value
+		}
+	}
+
+	return values
+}
+
+// applyCVars overrides config options with cvar values, invalid values are skipped.
+func applyCVars(cfg *Config, values map[string]string) []error {
+	var errs []error
+
+	for _, override := range cvarOverrides {
+		value, ok := values[override.name]
+		if !ok {
+			continue
+		}
+
+		if err := override.apply(cfg, value); err != nil {
+			errs = append(errs, errors.WithMessage(err, override.name))
+		}
+	}
+
+	return errs
+}
+
+// pollCVars applies changed cvars. It is called every frame, so cvars set in server.cfg
+// and changed at runtime are picked up. The port change restarts the listener.
+// The config is reloaded in the background, sv_downloadurl is updated on a later poll once it is applied.
+func (p *Plugin) pollCVars(engineFuncs *metamod.EngineFuncs) {
+	if time.Since(p.cvarsPolled) < cvarPollInterval {
+		return
+	}
+
+	p.cvarsPolled = time.Now()
+
+	if p.Config() != nil {
+		p.updateDownloadURL(engineFuncs)
+	}
+
+	values := readCVars(engineFuncs)
+
+	p.stateMu.Lock()
+	changed := !maps.Equal(values, p.cvars)
+	p.cvars = values
+	p.stateMu.Unlock()
+
+	if !changed || p.Config() == nil {
+		return
+	}
+
+	slog.Info("FastDL cvars changed", "cvars", fmt.Sprint(values))
+
+	current := p.Config()
+
+	updated := *current
+	_ = applyCVars(&updated, values)
+
+	if updated.Port != current.Port && p.handlers.current.Load() != nil {
+		if err := p.listen(current.BindAddress, updated.Port, current.HTTP); err != nil {
+			slog.Error("Failed to change port", "port", updated.Port, "error", err)
+		} else {
+			p.setPort(updated.Port)
+		}
+	}
+
+	go func() {
+		if err := p.Reload(); err != nil {
+			slog.Error("Failed to apply cvars", "error", err)
+		}
+	}()
+}
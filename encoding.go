@@ -0,0 +1,93 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// filenameCharsets are the legacy code pages of community content, file names made on
+// Windows with a Russian or Western European locale.
+var filenameCharsets = map[string]*charmap.Charmap{
+	"cp1251":       charmap.Windows1251,
+	"windows-1251": charmap.Windows1251,
+	"cp1252":       charmap.Windows1252,
+	"windows-1252": charmap.Windows1252,
+}
+
+// filenameEncodings translates file names between UTF-8 and legacy code pages.
+// Clients request names in one encoding while the disk or the precache list may have another.
+type filenameEncodings []*charmap.Charmap
+
+func newFilenameEncodings(names []string) (filenameEncodings, error) {
+	encodings := make(filenameEncodings, 0, len(names))
+
+	for _, name := range names {
+		charset, ok := filenameCharsets[strings.ToLower(name)]
+		if !ok {
+			return nil, errors.Errorf("unknown filename encoding %q, expected cp1251 or cp1252", name)
+		}
+
+		encodings = append(encodings, charset)
+	}
+
+	return encodings, nil
+}
+
+// Variants returns the name followed by its translations: names in a legacy code page are decoded
+// to UTF-8, UTF-8 names are encoded to each code page they can be represented in.
+func (e filenameEncodings) Variants(name string) []string {
+	variants := []string{name}
+
+	if len(e) == 0 || isASCII(name) {
+		return variants
+	}
+
+	valid := utf8.ValidString(name)
+
+	for _, charset := range e {
+		var (
+			variant string
+			err     error
+		)
+
+		if valid {
+			variant, err = charset.NewEncoder().String(name)
+		} else {
+			variant, err = charset.NewDecoder().String(name)
+		}
+
+		if err == nil && !slices.Contains(variants, variant) {
+			variants = append(variants, variant)
+		}
+	}
+
+	return variants
+}
+
+// DisplayName returns the name in UTF-8, names in a legacy code page are decoded with the first encoding.
+func (e filenameEncodings) DisplayName(name string) string {
+	if len(e) == 0 || utf8.ValidString(name) {
+		return name
+	}
+
+	decoded, err := e[0].NewDecoder().String(name)
+	if err != nil {
+		return name
+	}
+
+	return decoded
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileHandlerLegacyNameRules(t *testing.T) {
+	gameDir := t.TempDir()
+
+	files := []string{
+		filepath.Join("sound", mustEncodeCP1251(t, "секрет"), "a.wav"),
+		filepath.Join("sound", mustEncodeCP1251(t, "музыка"), "a.wav"),
+		filepath.Join(mustEncodeCP1251(t, "звуки"), "a.wav"),
+	}
+
+	for _, name := range files {
+		name = filepath.Join(gameDir, name)
+
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(name, []byte("sound"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.FilenameEncodings = []string{"cp1251"}
+	cfg.Rules = []string{"!sound/секрет/**"}
+	cfg.AllowedPaths = append(cfg.AllowedPaths, "звуки")
+
+	h, err := newFileHandler(gameDir, NewPlugin(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer h.fs.Close()
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/sound/секрет/a.wav", want: http.StatusNotFound},
+		{path: "/sound/" + mustEncodeCP1251(t, "секрет") + "/a.wav", want: http.StatusNotFound},
+		{path: "/sound/музыка/a.wav", want: http.StatusOK},
+		{path: "/звуки/a.wav", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL = &url.URL{Path: tt.path}
+
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.want {
+			t.Errorf("GET %q = %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envPrefix     = "FASTDL_"
+	envConfigFile = "environment"
+)
+
+// applyEnvironment overrides config options with FASTDL_* variables from environ.
+// Variable names are derived from YAML keys, e.g. FASTDL_ALLOWED_EXTENSIONS, FASTDL_HTTP_READ_TIMEOUT.
+// Values are parsed as YAML, lists of scalars can also be comma-separated.
+func applyEnvironment(cfg *Config, environ []string) ConfigIssues {
+	values := make(map[string]string)
+
+	for _, item := range environ {
+		name, value, ok := strings.Cut(item, "=")
+		if ok && strings.HasPrefix(name, envPrefix) {
+			values[name] = value
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	v := &configValidator{file: envConfigFile}
+	known := make(map[string]struct{})
+
+	applyEnvironmentStruct(v, reflect.ValueOf(cfg).Elem(), envPrefix, values, known)
+
+	for name := range values {
+		if _, ok := known[name]; !ok {
+			v.warnf(name, "unknown variable, it is ignored")
+		}
+	}
+
+	return v.issues
+}
+
+func applyEnvironmentStruct(
+	v *configValidator,
+	s reflect.Value,
+	prefix string,
+	values map[string]string,
+	known map[string]struct{},
+) {
+	for key, field := range yamlFields(s.Type()) {
+		name := prefix + envName(key)
+		fieldValue := s.FieldByIndex(field.Index)
+
+		known[name] = struct{}{}
+
+		if value, ok := values[name]; ok {
+			if err := decodeEnvValue(fieldValue, value); err != nil {
+				v.errorf(name, "%s", err)
+			}
+		}
+
+		// Nested options, e.g. FASTDL_HTTP_READ_TIMEOUT, are applied after the whole struct.
+		if fieldValue.Kind() == reflect.Struct {
+			applyEnvironmentStruct(v, fieldValue, name+"_", values, known)
+		}
+	}
+}
+
+func decodeEnvValue(field reflect.Value, value string) error {
+	t := field.Type()
+	trimmed := strings.TrimSpace(value)
+
+	var node *yaml.Node
+
+	switch {
+	case t.Kind() == reflect.String:
+		node = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	case t.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(trimmed)
+		if err != nil {
+			return errors.Errorf("invalid boolean %q, expected true, false, 1 or 0", value)
+		}
+
+		field.SetBool(b)
+
+		return nil
+	case t.Kind() == reflect.Slice && isScalarKind(t.Elem().Kind()) &&
+		!strings.HasPrefix(trimmed, "[") && !strings.HasPrefix(trimmed, "{"):
+		node = &yaml.Node{Kind: yaml.SequenceNode}
+
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+
+			itemNode := &yaml.Node{Kind: yaml.ScalarNode, Value: item}
+			if t.Elem().Kind() == reflect.String {
+				itemNode.Tag = "!!str"
+			}
+
+			node.Content = append(node.Content, itemNode)
+		}
+	default:
+		var doc yaml.Node
+
+		if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+			return errors.Errorf("invalid value %q: %s", value, strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+
+		if len(doc.Content) == 0 {
+			return nil
+		}
+
+		node = doc.Content[0]
+	}
+
+	if err := node.Decode(field.Addr().Interface()); err != nil {
+		return errors.Errorf("invalid value %q: %s", value, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+
+	return nil
+}
+
+func isScalarKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Array, reflect.Chan, reflect.Func, reflect.Interface,
+		reflect.Map, reflect.Pointer, reflect.Slice, reflect.Struct:
+		return false
+	default:
+		return true
+	}
+}
+
+// envName converts a YAML key to a variable name: customDownloadURL to CUSTOM_DOWNLOAD_URL.
+func envName(key string) string {
+	runes := []rune(key)
+
+	var sb strings.Builder
+
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				sb.WriteByte('_')
+			}
+		}
+
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+
+	return sb.String()
+}
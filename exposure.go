@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const defaultExposureLargeFileSize = 50 * 1024 * 1024
+
+// Extensions of text files, they may contain configs or credentials.
+var textExtensions = map[string]struct{}{
+	"as":   {},
+	"cfg":  {},
+	"ini":  {},
+	"json": {},
+	"log":  {},
+	"lst":  {},
+	"res":  {},
+	"txt":  {},
+	"xml":  {},
+	"yaml": {},
+	"yml":  {},
+}
+
+var exposurePluginDirs = map[string]struct{}{
+	"addons":  {},
+	"amxmodx": {},
+	"metamod": {},
+	"plugins": {},
+}
+
+// ExposureReport lists every file the file handler would serve.
+type ExposureReport struct {
+	Dirs       []*ExposureDir `json:"dirs"`
+	TotalFiles int            `json:"totalFiles"`
+	TotalSize  int64          `json:"totalSize"`
+	RiskyFiles int            `json:"riskyFiles"`
+}
+
+type ExposureDir struct {
+	Path  string          `json:"path"`
+	Size  int64           `json:"size"`
+	Files []*ExposureFile `json:"files"`
+}
+
+type ExposureFile struct {
+	Path  string   `json:"path"`
+	Size  int64    `json:"size"`
+	Risks []string `json:"risks,omitempty"`
+}
+
+// BuildExposureReport walks the game directory and evaluates every file with the handler rules.
+func BuildExposureReport(h *fileHandler, largeFileSize int64) (*ExposureReport, error) {
+	if largeFileSize <= 0 {
+		largeFileSize = defaultExposureLargeFileSize
+	}
+
+	report := &ExposureReport{}
+	dirs := make(map[string]*ExposureDir)
+
+	err := fs.WalkDir(h.fs, ".", func(relPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable entries, they can not be served either.
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if !h.fileAllowed("/" + relPath) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		file := &ExposureFile{
+			Path:  relPath,
+			Size:  info.Size(),
+			Risks: exposureRisks(relPath, info.Size(), largeFileSize),
+		}
+
+		dirPath := path.Dir(relPath)
+
+		dir, ok := dirs[dirPath]
+		if !ok {
+			dir = &ExposureDir{Path: dirPath}
+			dirs[dirPath] = dir
+			report.Dirs = append(report.Dirs, dir)
+		}
+
+		dir.Files = append(dir.Files, file)
+		dir.Size += file.Size
+
+		report.TotalFiles++
+		report.TotalSize += file.Size
+
+		if len(file.Risks) > 0 {
+			report.RiskyFiles++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to walk game directory")
+	}
+
+	slices.SortFunc(report.Dirs, func(a, b *ExposureDir) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return report, nil
+}
+
+func exposureRisks(relPath string, size, largeFileSize int64) []string {
+	var risks []string
+
+	lowerPath := strings.ToLower(relPath)
+	parts := strings.Split(lowerPath, "/")
+
+	for _, part := range parts[:len(parts)-1] {
+		if _, ok := exposurePluginDirs[part]; ok {
+			risks = append(risks, "plugin directory")
+
+			break
+		}
+	}
+
+	ext := strings.TrimPrefix(path.Ext(lowerPath), ".")
+	if _, ok := textExtensions[ext]; ok && parts[0] != "maps" {
+		risks = append(risks, "text file outside maps")
+	}
+
+	if size > largeFileSize {
+		risks = append(risks, "large file")
+	}
+
+	return risks
+}
+
+// WriteText writes the report in a human-readable form, risky files are marked with "!".
+func (r *ExposureReport) WriteText(w io.Writer) error {
+	for _, dir := range r.Dirs {
+		_, err := fmt.Fprintf(w, "%s/ (%d files, %s)\n", dir.Path, len(dir.Files), formatSize(dir.Size))
+		if err != nil {
+			return err
+		}
+
+		for _, file := range dir.Files {
+			mark := " "
+			if len(file.Risks) > 0 {
+				mark = "!"
+			}
+
+			line := fmt.Sprintf(" %s %-48s %10s", mark, path.Base(file.Path), formatSize(file.Size))
+			if len(file.Risks) > 0 {
+				line += "  " + strings.Join(file.Risks, ", ")
+			}
+
+			if _, err = fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(w,
+		"Total: %d files, %s, %d risky\n",
+		r.TotalFiles,
+		formatSize(r.TotalSize),
+		r.RiskyFiles,
+	)
+
+	return err
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const listIPFileName = "listip.cfg"
+
+// GameBans mirrors the game server IP filter list.
+// Permanent bans are read from listip.cfg, bans issued with addip and removeip
+// server commands are tracked as they happen, including their expiry.
+// Only commands issued through pfnServerCommand by the game and plugins are seen,
+// commands from the console and rcon reach the plugin through listip.cfg,
+// which is written with writeip on every map start.
+type GameBans struct {
+	source *blockListSource
+
+	permanent atomic.Pointer[ipFilterSet]
+
+	mu      sync.RWMutex
+	issued  []gameBan
+	removed []ipFilter
+}
+
+type gameBan struct {
+	filter  ipFilter
+	expires time.Time // zero for permanent bans
+}
+
+func NewGameBans() *GameBans {
+	return &GameBans{}
+}
+
+// Load reads listip.cfg from the game directory.
+func (gb *GameBans) Load(gameDir string) {
+	gb.source = &blockListSource{path: filepath.Join(gameDir, listIPFileName)}
+
+	gb.reload(true)
+}
+
+// Watch polls listip.cfg for changes and drops expired bans until ctx is done.
+func (gb *GameBans) Watch(ctx context.Context) {
+	ticker := time.NewTicker(blockListPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			gb.reload(false)
+			gb.dropExpired(time.Now())
+		}
+	}
+}
+
+func (gb *GameBans) Blocked(addr netip.Addr) bool {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+
+	now := time.Now()
+
+	for _, ban := range gb.issued {
+		if ban.filter.Contains(addr) && (ban.expires.IsZero() || now.Before(ban.expires)) {
+			return true
+		}
+	}
+
+	if !gb.permanent.Load().Contains(addr) {
+		return false
+	}
+
+	for _, f := range gb.removed {
+		if f.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HandleServerCommand tracks addip and removeip commands from a server command string.
+func (gb *GameBans) HandleServerCommand(str string) {
+	commands := strings.FieldsFunc(str, func(r rune) bool {
+		return r == ';' || r == '\n'
+	})
+
+	for _, command := range commands {
+		args := strings.Fields(strings.ReplaceAll(command, "\"", ""))
+		if len(args) == 0 {
+			continue
+		}
+
+		var err error
+
+		switch strings.ToLower(args[0]) {
+		case "addip":
+			err = gb.handleAddIP(args[1:])
+		case "removeip":
+			err = gb.handleRemoveIP(args[1:])
+		default:
+			continue
+		}
+
+		if err != nil {
+			slog.Warn("Failed to track game ban command", "command", command, "error", err)
+		}
+	}
+}
+
+func (gb *GameBans) handleAddIP(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: addip <minutes> <ipaddress>")
+	}
+
+	minutes, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return errors.WithMessage(err, "invalid ban duration")
+	}
+
+	f, err := parseIPFilter(args[1])
+	if err != nil {
+		return err
+	}
+
+	ban := gameBan{filter: f}
+	if minutes > 0 {
+		ban.expires = time.Now().Add(time.Duration(minutes * float64(time.Minute)))
+	}
+
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+
+	gb.issued = slices.DeleteFunc(gb.issued, func(b gameBan) bool {
+		return b.filter == f
+	})
+	gb.issued = append(gb.issued, ban)
+	gb.removed = slices.DeleteFunc(gb.removed, func(removed ipFilter) bool {
+		return removed == f
+	})
+
+	slog.Info("Game ban added", "ip", f.String(), "expires", ban.expires)
+
+	return nil
+}
+
+func (gb *GameBans) handleRemoveIP(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: removeip <ipaddress>")
+	}
+
+	f, err := parseIPFilter(args[0])
+	if err != nil {
+		return err
+	}
+
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+
+	gb.issued = slices.DeleteFunc(gb.issued, func(b gameBan) bool {
+		return b.filter == f
+	})
+	gb.removed = append(gb.removed, f)
+
+	slog.Info("Game ban removed", "ip", f.String())
+
+	return nil
+}
+
+func (gb *GameBans) reload(force bool) {
+	if gb.source == nil {
+		return
+	}
+
+	updated, err := gb.source.reload(force)
+	if err != nil {
+		slog.Debug("Failed to load game bans", "source", gb.source.path, "error", err)
+	}
+
+	if !updated {
+		return
+	}
+
+	gb.permanent.Store(newIPFilterSet(gb.source.ranges, gb.source.filters))
+
+	// listip.cfg is the source of truth for permanent bans again.
+	gb.mu.Lock()
+	gb.removed = nil
+	gb.mu.Unlock()
+}
+
+func (gb *GameBans) dropExpired(now time.Time) {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+
+	gb.issued = slices.DeleteFunc(gb.issued, func(b gameBan) bool {
+		return !b.expires.IsZero() && !now.Before(b.expires)
+	})
+}
+
+// ipFilter is an IPv4 filter of the engine IP filter list.
+// Each octet of the mask is 255 or 0, a zero octet is a wildcard at any position.
+type ipFilter struct {
+	addr [4]byte
+	mask [4]byte
+}
+
+// parseIPFilter parses an IPv4 filter in the engine format.
+// Zero and missing octets are wildcards like in the engine, so "192.168" matches 192.168.0.0/16
+// and "10.0.5.1" matches 10.*.5.1.
+func parseIPFilter(s string) (ipFilter, error) {
+	octets := strings.Split(s, ".")
+	if len(octets) == 0 || len(octets) > 4 {
+		return ipFilter{}, errors.Errorf("invalid ip filter %q", s)
+	}
+
+	var f ipFilter
+
+	for i, octet := range octets {
+		value, err := strconv.ParseUint(octet, 10, 8)
+		if err != nil {
+			return ipFilter{}, errors.Errorf("invalid ip filter %q", s)
+		}
+
+		if value != 0 {
+			f.addr[i] = byte(value)
+			f.mask[i] = 0xff
+		}
+	}
+
+	return f, nil
+}
+
+// Contains reports whether the IPv4 address matches the filter.
+func (f ipFilter) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.Is4() {
+		return false
+	}
+
+	ip := addr.As4()
+
+	for i := range ip {
+		if ip[i]&f.mask[i] != f.addr[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Range returns the filter as an address range, false if a wildcard is followed by a fixed octet.
+func (f ipFilter) Range() (IPRange, bool) {
+	var to [4]byte
+
+	for i := range to {
+		if i > 0 && f.mask[i] != 0 && f.mask[i-1] == 0 {
+			return IPRange{}, false
+		}
+
+		to[i] = f.addr[i] | ^f.mask[i]
+	}
+
+	return IPRange{From: netip.AddrFrom4(f.addr), To: netip.AddrFrom4(to)}, true
+}
+
+// String returns the filter with wildcards as "*", e.g. "10.*.5.1".
+func (f ipFilter) String() string {
+	octets := make([]string, len(f.addr))
+
+	for i := range f.addr {
+		if f.mask[i] == 0 {
+			octets[i] = "*"
+		} else {
+			octets[i] = strconv.Itoa(int(f.addr[i]))
+		}
+	}
+
+	return strings.Join(octets, ".")
+}
+
+// ipFilterSet matches address ranges and engine filters that can not be expressed as a range.
+type ipFilterSet struct {
+	ranges  *IPRangeSet
+	filters []ipFilter
+}
+
+func newIPFilterSet(ranges []IPRange, filters []ipFilter) *ipFilterSet {
+	return &ipFilterSet{
+		ranges:  NewIPRangeSet(ranges),
+		filters: filters,
+	}
+}
+
+func (s *ipFilterSet) Contains(addr netip.Addr) bool {
+	if s == nil {
+		return false
+	}
+
+	if s.ranges.Contains(addr) {
+		return true
+	}
+
+	for _, f := range s.filters {
+		if f.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
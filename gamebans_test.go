@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseIPFilter(t *testing.T) {
+	tests := []struct {
+		filter  string
+		want    string
+		match   []string
+		noMatch []string
+	}{
+		{filter: "10.0.5.1", want: "10.*.5.1", match: []string{"10.0.5.1", "10.7.5.1", "::ffff:10.9.5.1"}, noMatch: []string{"10.0.5.2", "11.0.5.1"}},
+		{filter: "192.168.0.0", want: "192.168.*.*", match: []string{"192.168.0.1", "192.168.255.255"}, noMatch: []string{"192.169.0.1"}},
+		{filter: "192.168", want: "192.168.*.*", match: []string{"192.168.10.1"}, noMatch: []string{"192.167.10.1"}},
+		{filter: "0.0.0.5", want: "*.*.*.5", match: []string{"1.2.3.5", "200.0.0.5"}, noMatch: []string{"1.2.3.4"}},
+		{filter: "1.2.3.4", want: "1.2.3.4", match: []string{"1.2.3.4"}, noMatch: []string{"1.2.3.5", "2001:db8::1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := parseIPFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("parseIPFilter(%q) error: %v", tt.filter, err)
+			}
+
+			if got := f.String(); got != tt.want {
+				t.Errorf("parseIPFilter(%q) = %s, want %s", tt.filter, got, tt.want)
+			}
+
+			for _, addr := range tt.match {
+				if !f.Contains(netip.MustParseAddr(addr)) {
+					t.Errorf("%s does not contain %s", f, addr)
+				}
+			}
+
+			for _, addr := range tt.noMatch {
+				if f.Contains(netip.MustParseAddr(addr)) {
+					t.Errorf("%s contains %s", f, addr)
+				}
+			}
+		})
+	}
+
+	for _, filter := range []string{"", "1.2.3.4.5", "256.0.0.1", "a.b.c.d"} {
+		if _, err := parseIPFilter(filter); err == nil {
+			t.Errorf("parseIPFilter(%q) succeeded, want error", filter)
+		}
+	}
+}
+
+func TestIPFilterRange(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   string
+		ok     bool
+	}{
+		{filter: "1.2.3.4", want: "1.2.3.4", ok: true},
+		{filter: "192.168.0.0", want: "192.168.0.0-192.168.255.255", ok: true},
+		{filter: "0.0.0.0", want: "0.0.0.0-255.255.255.255", ok: true},
+		{filter: "10.0.5.1", ok: false},
+		{filter: "0.0.0.5", ok: false},
+	}
+
+	for _, tt := range tests {
+		f, err := parseIPFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("parseIPFilter(%q) error: %v", tt.filter, err)
+		}
+
+		r, ok := f.Range()
+		if ok != tt.ok {
+			t.Fatalf("%s.Range() ok = %t, want %t", f, ok, tt.ok)
+		}
+
+		if ok && r.String() != tt.want {
+			t.Errorf("%s.Range() = %s, want %s", f, r, tt.want)
+		}
+	}
+}
+
+func TestGameBansServerCommands(t *testing.T) {
+	gb := NewGameBans()
+
+	gb.HandleServerCommand("addip 0 10.0.5.1; addip 5 192.168.1.0\n")
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "10.3.5.1", want: true},
+		{addr: "10.3.5.2", want: false},
+		{addr: "192.168.1.77", want: true},
+		{addr: "192.168.2.77", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := gb.Blocked(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Blocked(%s) = %t, want %t", tt.addr, got, tt.want)
+		}
+	}
+
+	gb.HandleServerCommand("removeip 10.0.5.1")
+
+	if gb.Blocked(netip.MustParseAddr("10.3.5.1")) {
+		t.Error("10.3.5.1 is blocked after removeip")
+	}
+}
+
+func TestParseBlockListFileIPFilters(t *testing.T) {
+	ranges, filters, errs := parseBlockListFile([]byte("addip 0.0 192.168.0.0\naddip 0.0 10.0.5.1\n"))
+	if len(errs) > 0 {
+		t.Fatalf("parseBlockListFile errors: %v", errs)
+	}
+
+	set := newIPFilterSet(ranges, filters)
+
+	for addr, want := range map[string]bool{
+		"192.168.3.4": true,
+		"10.200.5.1":  true,
+		"10.200.5.2":  false,
+		"8.8.8.8":     false,
+	} {
+		if got := set.Contains(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Contains(%s) = %t, want %t", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	symlinksDeny               = "deny"
+	symlinksAllowWithinGameDir = "allow-within-gamedir"
+	symlinksAllow              = "allow"
+)
+
+// gameFS is a read-only view of the game directory used to serve files.
+// Names are slash-separated paths relative to the game directory.
+// Unlike io/fs, names are not required to be valid UTF-8, legacy content has names in other code pages.
+//
+// Symlink policies:
+//   - deny: paths with a symlink in any component do not exist
+//   - allow-within-gamedir: symlinks are followed if the target is inside the game directory
+//   - allow: symlinks are followed anywhere
+type gameFS struct {
+	baseDir      string
+	root         *os.Root
+	denySymlinks bool
+}
+
+var (
+	_ fs.StatFS     = (*gameFS)(nil)
+	_ fs.ReadDirFS  = (*gameFS)(nil)
+	_ fs.ReadFileFS = (*gameFS)(nil)
+)
+
+func newGameFS(baseDir string, symlinks string) (*gameFS, error) {
+	switch symlinks {
+	case symlinksAllow:
+		return &gameFS{baseDir: baseDir}, nil
+	case "", symlinksAllowWithinGameDir, symlinksDeny:
+	default:
+		return nil, errors.Errorf(
+			"invalid symlinks policy %q, expected %q, %q or %q",
+			symlinks,
+			symlinksDeny,
+			symlinksAllowWithinGameDir,
+			symlinksAllow,
+		)
+	}
+
+	root, err := os.OpenRoot(baseDir)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to open game directory")
+	}
+
+	return &gameFS{
+		baseDir:      baseDir,
+		root:         root,
+		denySymlinks: symlinks == symlinksDeny,
+	}, nil
+}
+
+func (g *gameFS) Close() error {
+	if g.root == nil {
+		return nil
+	}
+
+	return g.root.Close()
+}
+
+func (g *gameFS) Open(name string) (fs.File, error) {
+	if err := g.checkSymlinks("open", name); err != nil {
+		return nil, err
+	}
+
+	return g.open(name)
+}
+
+func (g *gameFS) Stat(name string) (fs.FileInfo, error) {
+	if err := g.checkSymlinks("stat", name); err != nil {
+		return nil, err
+	}
+
+	if g.root == nil {
+		return os.Stat(g.path(name))
+	}
+
+	return g.root.Stat(filepath.FromSlash(name))
+}
+
+func (g *gameFS) ReadFile(name string) ([]byte, error) {
+	if err := g.checkSymlinks("read", name); err != nil {
+		return nil, err
+	}
+
+	f, err := g.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// ReadDir returns the directory entries sorted by name.
+func (g *gameFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := g.checkSymlinks("readdir", name); err != nil {
+		return nil, err
+	}
+
+	f, err := g.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := f.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	if !g.denySymlinks {
+		return entries, nil
+	}
+
+	visible := entries[:0]
+
+	for _, entry := range entries {
+		if entry.Type()&fs.ModeSymlink == 0 {
+			visible = append(visible, entry)
+		}
+	}
+
+	return visible, nil
+}
+
+func (g *gameFS) open(name string) (*os.File, error) {
+	if g.root == nil {
+		return os.Open(g.path(name))
+	}
+
+	return g.root.Open(filepath.FromSlash(name))
+}
+
+func (g *gameFS) path(name string) string {
+	return filepath.Join(g.baseDir, filepath.FromSlash(name))
+}
+
+// checkSymlinks rejects names with a symlink in any component when symlinks are denied.
+func (g *gameFS) checkSymlinks(op, name string) error {
+	if !validGameFSName(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	if !g.denySymlinks || name == "." {
+		return nil
+	}
+
+	for current := name; current != "."; current = path.Dir(current) {
+		info, err := g.root.Lstat(filepath.FromSlash(current))
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+	}
+
+	return nil
+}
+
+// validGameFSName is fs.ValidPath allowing names in legacy code pages.
+// Bytes of invalid UTF-8 sequences are never "/" or ".", so they do not change the path elements.
+func validGameFSName(name string) bool {
+	return fs.ValidPath(strings.ToValidUTF8(name, "_"))
+}
+
+// gameFSName converts a cleaned URL path into a gameFS name.
+func gameFSName(requestedPath string) string {
+	name := strings.Trim(path.Clean("/"+requestedPath), "/")
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
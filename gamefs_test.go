@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	fuzzGameFile    = "GAMEFILE"
+	fuzzSymlinkFile = "SYMLINKED_SECRET"
+	fuzzOutsideFile = "OUTSIDE_SECRET"
+)
+
+var traversalSeeds = []string{
+	"maps/a.txt",
+	"../outside.txt",
+	"../../outside.txt",
+	"maps/../../outside.txt",
+	"%2e%2e/outside.txt",
+	"%2e%2e%2foutside.txt",
+	"%2e%2e%2f%2e%2e%2foutside.txt",
+	"maps/%2e%2e/%2e%2e/outside.txt",
+	"..%2foutside.txt",
+	`..\outside.txt`,
+	`..\..\outside.txt`,
+	`maps\..\..\outside.txt`,
+	"..%5coutside.txt",
+	"..%5c..%5coutside.txt",
+	"%252e%252e/outside.txt",
+	"%252e%252e%252foutside.txt",
+	"..%252foutside.txt",
+	"%2e%2e%255coutside.txt",
+	".%2e/outside.txt",
+	"./../outside.txt",
+	"/../outside.txt",
+	"//../outside.txt",
+	"maps/escape.txt",
+	"maps/outdir/secret.txt",
+	"maps/outdir/outside.txt",
+	"MAPS/ESCAPE.TXT",
+	"maps/outdir/../secret.txt",
+	"maps/outdir/../../outside.txt",
+	"maps/outdir/%2e%2e/%2e%2e/outside.txt",
+	"maps/inside.txt",
+	"..+/outside.txt",
+}
+
+// newTraversalFixture creates a game directory with symlinks escaping it:
+//
+//	root/secret.txt               target of the escaping file symlink
+//	root/linked/secret.txt        target of the escaping directory symlink
+//	root/outside.txt              not linked, never reachable
+//	root/cstrike/maps/a.txt
+//	root/cstrike/maps/escape.txt  -> ../../secret.txt
+//	root/cstrike/maps/outdir      -> ../../linked
+//	root/cstrike/maps/inside.txt  -> a.txt
+func newTraversalFixture(tb testing.TB) string {
+	tb.Helper()
+
+	root := tb.TempDir()
+	gameDir := filepath.Join(root, "cstrike")
+
+	files := map[string]string{
+		filepath.Join(root, "secret.txt"):           fuzzSymlinkFile,
+		filepath.Join(root, "linked", "secret.txt"): fuzzSymlinkFile,
+		filepath.Join(root, "outside.txt"):          fuzzOutsideFile,
+		filepath.Join(gameDir, "maps", "a.txt"):     fuzzGameFile,
+		filepath.Join(gameDir, "liblist.gam"):       `game "Counter-Strike"`,
+		filepath.Join(gameDir, "maps", "b.txt"):     fuzzGameFile,
+		filepath.Join(root, "valve", "maps", "c"):   fuzzGameFile,
+	}
+
+	for name, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			tb.Fatal(err)
+		}
+
+		if err := os.WriteFile(name, []byte(contents), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	symlinks := map[string]string{
+		filepath.Join(gameDir, "maps", "escape.txt"): filepath.Join("..", "..", "secret.txt"),
+		filepath.Join(gameDir, "maps", "outdir"):     filepath.Join("..", "..", "linked"),
+		filepath.Join(gameDir, "maps", "inside.txt"): "a.txt",
+	}
+
+	for name, target := range symlinks {
+		if err := os.Symlink(target, name); err != nil {
+			tb.Skipf("symlinks are not supported: %v", err)
+		}
+	}
+
+	return gameDir
+}
+
+// leaked reports whether the contents come from outside the game directory
+// in a way the symlink policy does not permit.
+func leaked(policy string, contents []byte) bool {
+	if bytes.Contains(contents, []byte(fuzzOutsideFile)) {
+		return true
+	}
+
+	return policy != symlinksAllow && bytes.Contains(contents, []byte(fuzzSymlinkFile))
+}
+
+var symlinkPolicies = []string{symlinksDeny, symlinksAllowWithinGameDir, symlinksAllow}
+
+func FuzzGameFS(f *testing.F) {
+	for _, seed := range traversalSeeds {
+		f.Add(seed)
+
+		if unescaped, err := url.PathUnescape(seed); err == nil {
+			f.Add(unescaped)
+		}
+	}
+
+	gameDir := newTraversalFixture(f)
+
+	fileSystems := make(map[string]*gameFS, len(symlinkPolicies))
+
+	for _, policy := range symlinkPolicies {
+		g, err := newGameFS(gameDir, policy)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Cleanup(func() { _ = g.Close() })
+
+		fileSystems[policy] = g
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		for policy, g := range fileSystems {
+			if contents, err := g.ReadFile(name); err == nil && leaked(policy, contents) {
+				t.Fatalf("%s: ReadFile(%q) read a file outside the game directory", policy, name)
+			}
+
+			if contents, err := g.ReadFile(gameFSName(NormalizePath(name))); err == nil && leaked(policy, contents) {
+				t.Fatalf("%s: ReadFile(%q) read a file outside the game directory", policy, name)
+			}
+
+			if entries, err := g.ReadDir(name); err == nil {
+				for _, entry := range entries {
+					if entry.Name() == "outside.txt" {
+						t.Fatalf("%s: ReadDir(%q) listed the parent directory", policy, name)
+					}
+				}
+			}
+		}
+	})
+}
+
+func FuzzFileHandler(f *testing.F) {
+	for _, seed := range traversalSeeds {
+		f.Add(seed)
+	}
+
+	gameDir := newTraversalFixture(f)
+
+	handlers := make(map[string]*fileHandler, len(symlinkPolicies))
+
+	for _, policy := range symlinkPolicies {
+		cfg := DefaultConfig()
+		cfg.Symlinks = policy
+		cfg.AutoIndexEnabled = true
+		cfg.FilenameEncodings = []string{"cp1251"}
+
+		h, err := newFileHandler(gameDir, NewPlugin(), cfg)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Cleanup(func() { _ = h.fs.Close() })
+
+		handlers[policy] = h
+	}
+
+	f.Fuzz(func(t *testing.T, requestPath string) {
+		u, err := url.ParseRequestURI("/" + requestPath)
+		if err != nil {
+			return
+		}
+
+		for policy, h := range handlers {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.URL = u
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			body := w.Body.Bytes()
+
+			if leaked(policy, body) {
+				t.Fatalf("%s: GET %q served a file outside the game directory", policy, u.EscapedPath())
+			}
+
+			if bytes.Contains(body, []byte("outside.txt")) {
+				t.Fatalf("%s: GET %q listed the parent directory", policy, u.EscapedPath())
+			}
+		}
+	})
+}
+
+func TestFileHandlerSymlinkPolicies(t *testing.T) {
+	gameDir := newTraversalFixture(t)
+
+	tests := []struct {
+		path   string
+		policy string
+		want   int
+	}{
+		{path: "/maps/a.txt", policy: symlinksDeny, want: http.StatusOK},
+		{path: "/maps/inside.txt", policy: symlinksDeny, want: http.StatusNotFound},
+		{path: "/maps/escape.txt", policy: symlinksDeny, want: http.StatusNotFound},
+		{path: "/maps/a.txt", policy: symlinksAllowWithinGameDir, want: http.StatusOK},
+		{path: "/maps/inside.txt", policy: symlinksAllowWithinGameDir, want: http.StatusOK},
+		{path: "/maps/escape.txt", policy: symlinksAllowWithinGameDir, want: http.StatusNotFound},
+		{path: "/maps/outdir/secret.txt", policy: symlinksAllowWithinGameDir, want: http.StatusNotFound},
+		{path: "/maps/inside.txt", policy: symlinksAllow, want: http.StatusOK},
+		{path: "/maps/escape.txt", policy: symlinksAllow, want: http.StatusOK},
+		{path: "/maps/outdir/secret.txt", policy: symlinksAllow, want: http.StatusOK},
+		{path: "/%2e%2e/outside.txt", policy: symlinksAllow, want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy+tt.path, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Symlinks = tt.policy
+
+			h, err := newFileHandler(gameDir, NewPlugin(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer h.fs.Close()
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"log/slog"
+	"net/netip"
+	"path/filepath"
+	"strings"
+
+	"github.com/Southclaws/swirl"
+	"github.com/Southclaws/swirl/memory"
+	"github.com/oschwald/maxminddb-golang/v2"
+	"github.com/pkg/errors"
+)
+
+const (
+	geoIPActionAllow = "allow"
+	geoIPActionDeny  = "deny"
+	geoIPActionLimit = "limit"
+)
+
+// GeoIP applies access rules by country and ASN from local MaxMind databases.
+type GeoIP struct {
+	readers []*maxminddb.Reader
+
+	rules         []*geoIPRule
+	defaultAction string
+}
+
+type geoIPRule struct {
+	countries map[string]struct{}
+	asns      map[uint]struct{}
+	action    string
+	limiter   *swirl.Limiter
+}
+
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	ASN uint `maxminddb:"autonomous_system_number"`
+}
+
+// GeoIPLocation is a result of looking up an address in all databases.
+type GeoIPLocation struct {
+	Country string
+	ASN     uint
+}
+
+// NewGeoIP opens databases, relative paths are resolved against baseDir.
+func NewGeoIP(baseDir string, cfg ConfigGeoIP) (*GeoIP, error) {
+	g := &GeoIP{
+		readers:       make([]*maxminddb.Reader, 0, len(cfg.Databases)),
+		rules:         make([]*geoIPRule, 0, len(cfg.Rules)),
+		defaultAction: strings.ToLower(cfg.DefaultAction),
+	}
+
+	if g.defaultAction == "" {
+		g.defaultAction = geoIPActionAllow
+	}
+
+	if g.defaultAction != geoIPActionAllow && g.defaultAction != geoIPActionDeny {
+		return nil, errors.Errorf("invalid geoip default action %q", cfg.DefaultAction)
+	}
+
+	for i, ruleCfg := range cfg.Rules {
+		rule, err := newGeoIPRule(ruleCfg)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid geoip rule #%d", i+1)
+		}
+
+		g.rules = append(g.rules, rule)
+	}
+
+	for _, database := range cfg.Databases {
+		database = geoIPDatabasePath(baseDir, database)
+
+		reader, err := maxminddb.Open(database)
+		if err != nil {
+			_ = g.Close()
+
+			return nil, errors.WithMessagef(err, "failed to open geoip database %s", database)
+		}
+
+		slog.Info("GeoIP database loaded",
+			"database", database,
+			"type", reader.Metadata.DatabaseType,
+			"buildTime", reader.Metadata.BuildTime(),
+		)
+
+		g.readers = append(g.readers, reader)
+	}
+
+	return g, nil
+}
+
+// geoIPDatabasePath resolves a database path relative to the game directory.
+func geoIPDatabasePath(baseDir, database string) string {
+	if filepath.IsAbs(database) {
+		return database
+	}
+
+	return filepath.Join(baseDir, database)
+}
+
+// checkGeoIPDatabase opens the database to report a missing or corrupt file before the server starts.
+func checkGeoIPDatabase(baseDir, database string) error {
+	reader, err := maxminddb.Open(geoIPDatabasePath(baseDir, database))
+	if err != nil {
+		return err
+	}
+
+	return reader.Close()
+}
+
+func newGeoIPRule(cfg ConfigGeoIPRule) (*geoIPRule, error) {
+	rule := &geoIPRule{
+		countries: make(map[string]struct{}, len(cfg.Countries)),
+		asns:      make(map[uint]struct{}, len(cfg.ASNs)),
+		action:    strings.ToLower(cfg.Action),
+	}
+
+	for _, country := range cfg.Countries {
+		rule.countries[strings.ToUpper(country)] = struct{}{}
+	}
+
+	for _, asn := range cfg.ASNs {
+		rule.asns[asn] = struct{}{}
+	}
+
+	if len(rule.countries) == 0 && len(rule.asns) == 0 {
+		return nil, errors.New("countries or asns must be set")
+	}
+
+	switch rule.action {
+	case geoIPActionAllow, geoIPActionDeny:
+	case geoIPActionLimit:
+		period := cfg.Period.Duration()
+		if period <= 0 || cfg.Limit <= 0 {
+			return nil, errors.New("limit and period must be set for limit action")
+		}
+
+		rule.limiter = swirl.New(memory.New(), cfg.Limit, period, period/10)
+	default:
+		return nil, errors.Errorf("invalid action %q", cfg.Action)
+	}
+
+	return rule, nil
+}
+
+func (g *GeoIP) Close() error {
+	var errs []error
+
+	for _, reader := range g.readers {
+		if err := reader.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Errorf("failed to close geoip databases: %v", errs)
+	}
+
+	return nil
+}
+
+// Lookup merges results from all databases.
+func (g *GeoIP) Lookup(addr netip.Addr) (GeoIPLocation, error) {
+	var location GeoIPLocation
+
+	addr = unmapAddr(addr)
+
+	for _, reader := range g.readers {
+		var record geoIPRecord
+
+		err := reader.Lookup(addr).Decode(&record)
+		if err != nil {
+			return location, errors.WithMessage(err, "failed to lookup address")
+		}
+
+		if location.Country == "" {
+			location.Country = record.Country.ISOCode
+		}
+
+		if location.Country == "" {
+			location.Country = record.RegisteredCountry.ISOCode
+		}
+
+		if location.ASN == 0 {
+			location.ASN = record.ASN
+		}
+	}
+
+	return location, nil
+}
+
+// Match returns the first rule matching the location, nil if none.
+func (g *GeoIP) Match(location GeoIPLocation) *geoIPRule {
+	for _, rule := range g.rules {
+		if rule.matches(location) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+func (r *geoIPRule) matches(location GeoIPLocation) bool {
+	if _, ok := r.countries[location.Country]; ok && location.Country != "" {
+		return true
+	}
+
+	if _, ok := r.asns[location.ASN]; ok && location.ASN != 0 {
+		return true
+	}
+
+	return false
+}
@@ -1,10 +1,17 @@
 module github.com/et-nik/fastdl-mm
 
-go 1.23
+go 1.24.0
 
 require (
 	github.com/Southclaws/swirl v1.0.1
 	github.com/et-nik/metamod-go v0.3.3
+	github.com/oschwald/maxminddb-golang/v2 v2.0.0
 	github.com/pkg/errors v0.9.1
+	golang.org/x/text v0.30.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require (
+	github.com/puzpuzpuz/xsync/v3 v3.4.0 // indirect
+	golang.org/x/sys v0.37.0 // indirect
+)
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"github.com/Southclaws/swirl/memory"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 	"time"
 
@@ -17,6 +20,12 @@ func rateLimitMiddleware(next http.Handler, period time.Duration, limit int) htt
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
+		if clientMembershipFromContext(ctx).BypassRateLimits() {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
 		ip, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
 			slog.Error("Failed to split remote address", "error", err)
@@ -46,72 +55,162 @@ func rateLimitMiddleware(next http.Handler, period time.Duration, limit int) htt
 	})
 }
 
-func ipBlockMiddleware(next http.Handler, blockList []string) http.Handler {
-	blockedSubnets := make([]*net.IPNet, 0, len(blockList))
-	blockedIPs := make(map[string]struct{}, len(blockList))
+func clientGroupsMiddleware(next http.Handler, groups *ClientGroups) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil {
+			slog.Error("Failed to parse remote address", "error", err)
+
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		membership := groups.Membership(addrPort.Addr())
 
-	for _, item := range blockList {
-		if strings.Contains(item, "/") {
-			_, ipNet, err := net.ParseCIDR(item)
+		if !groups.Allowed(membership) {
+			slog.Debug("Client is not in private groups", "ip", addrPort.Addr().String())
 
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withClientMembership(r.Context(), membership)))
+	})
+}
+
+func connectedClientsMiddleware(next http.Handler, tracker *ClientTracker, ttl time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil {
+			slog.Error("Failed to parse remote address", "error", err)
+
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if !tracker.Seen(addrPort.Addr(), ttl) {
+			slog.Debug("Client is not connected to the game server", "ip", addrPort.Addr().String())
+
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func geoIPMiddleware(next http.Handler, geoIP *GeoIP) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil {
+			slog.Error("Failed to parse remote address", "error", err)
+
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		location, err := geoIP.Lookup(addrPort.Addr())
+		if err != nil {
+			slog.Error("Failed to lookup GeoIP location", "ip", addrPort.Addr().String(), "error", err)
+		}
+
+		action := geoIP.defaultAction
+
+		rule := geoIP.Match(location)
+		if rule != nil {
+			action = rule.action
+		}
+
+		switch action {
+		case geoIPActionDeny:
+			slog.Info("Blocked by GeoIP",
+				"ip", addrPort.Addr().String(),
+				"country", location.Country,
+				"asn", location.ASN,
+			)
+
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		case geoIPActionLimit:
+			status, allowed, err := rule.limiter.Increment(ctx, addrPort.Addr().String(), 1)
 			if err != nil {
-				slog.Error("Failed to parse CIDR", "cidr", item, "error", err)
+				slog.Error("Failed to increment ratelimit", "error", err)
 
-				continue
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
 			}
 
-			blockedSubnets = append(blockedSubnets, ipNet)
-		} else if strings.Contains(item, "-") {
-			parts := strings.Split(item, "-")
-			if len(parts) != 2 {
-				slog.Error("Invalid range", "range", item)
+			if !allowed {
+				slog.Info("GeoIP rate limit exceeded",
+					"ip", addrPort.Addr().String(),
+					"country", location.Country,
+					"asn", location.ASN,
+				)
 
-				continue
+				w.Header().Set("Retry-After", status.Reset.UTC().Format(time.RFC1123))
+
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
 			}
+		}
 
-			start := net.ParseIP(parts[0])
-			end := net.ParseIP(parts[1])
-			if start == nil || end == nil {
-				slog.Error("Failed to parse IP range", "range", item)
+		next.ServeHTTP(w, r)
+	})
+}
 
-				continue
-			}
+const adminPathPrefix = "/_fastdl/"
+
+// adminMiddleware serves admin endpoints to members of admin client groups.
+func adminMiddleware(next http.Handler, files *fileHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, adminPathPrefix) || !clientMembershipFromContext(r.Context()).Admin() {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		switch strings.TrimPrefix(r.URL.Path, adminPathPrefix) {
+		case "exposure":
+			report, err := BuildExposureReport(files, files.Config().ExposureLargeFileSize.Int64())
+			if err != nil {
+				slog.Error("Failed to build exposure report", "error", err)
 
-			ipNet := IPToCidr(start, end)
-			if ipNet == nil {
-				slog.Error("Failed to create CIDR from range", "range", item)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
 
-				continue
+			if r.URL.Query().Get("format") == "json" {
+				w.Header().Set("Content-Type", "application/json")
+				err = json.NewEncoder(w).Encode(report)
+			} else {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				err = report.WriteText(w)
 			}
 
-			blockedSubnets = append(blockedSubnets, ipNet)
-		} else {
-			parsed := net.ParseIP(item)
-			if parsed != nil {
-				blockedIPs[item] = struct{}{}
+			if err != nil {
+				slog.Error("Failed to write exposure report", "error", err)
 			}
+		default:
+			http.NotFound(w, r)
 		}
-	}
+	})
+}
 
+func ipBlockMiddleware(next http.Handler, blockers ...ipBlocker) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
 		if err != nil {
-			slog.Error("Failed to split remote address", "error", err)
+			slog.Error("Failed to parse remote address", "error", err)
 
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
-		if _, blocked := blockedIPs[ip]; blocked {
-			slog.Info("Blocked IP", "ip", ip)
-
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
-			return
-		}
-
-		for _, ipNet := range blockedSubnets {
-			if ipNet.Contains(net.ParseIP(ip)) {
-				slog.Info("Blocked IP", "ip", ip)
+		for _, blocker := range blockers {
+			if blocker.Blocked(addrPort.Addr()) {
+				slog.Info("Blocked IP", "ip", addrPort.Addr().String())
 
 				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 				return
@@ -122,32 +221,58 @@ func ipBlockMiddleware(next http.Handler, blockList []string) http.Handler {
 	})
 }
 
-// IPToCidr https://github.com/raspi/ip-range-to-CIDR/blob/master/lib/cidr.go
-func IPToCidr(start, end net.IP) *net.IPNet {
-	if !((start.To4() != nil && end.To4() != nil) || (start.To16() != nil && end.To16() != nil)) {
-		return nil
-	}
+type statusRecorder struct {
+	http.ResponseWriter
 
-	if start.To4() != nil {
-		start = start.To4()
-	} else {
-		start = start.To16()
-	}
+	status int
+}
 
-	if end.To4() != nil {
-		end = end.To4()
-	} else {
-		end = end.To16()
-	}
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
 
-	mask := make([]byte, len(start))
+	r.ResponseWriter.WriteHeader(status)
+}
 
-	for idx := range start {
-		mask[idx] = 255 - (start[idx] ^ end[idx])
+// ReadFrom keeps the io.ReaderFrom fast path of the underlying writer for http.ServeContent.
+func (r *statusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if rf, ok := r.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
 	}
 
-	return &net.IPNet{
-		IP:   start,
-		Mask: mask,
-	}
+	return io.Copy(struct{ io.Writer }{r.ResponseWriter}, src)
+}
+
+func (r *statusRecorder) Flush() {
+	_ = http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func intrusionDetectionMiddleware(next http.Handler, detector *IntrusionDetector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil {
+			slog.Error("Failed to parse remote address", "error", err)
+
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if score, reason := inspectRequestPath(r.RequestURI); score > 0 {
+			detector.Report(addrPort.Addr(), score, reason)
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		switch recorder.status {
+		case http.StatusNotFound:
+			detector.Report(addrPort.Addr(), intrusionScoreNotFound, "too many not found requests")
+		case http.StatusTooManyRequests:
+			detector.Report(addrPort.Addr(), intrusionScoreTooManyRequests, "rate limit exceeded repeatedly")
+		}
+	})
 }
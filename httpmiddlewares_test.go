@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readerFromRecorder records whether the io.ReaderFrom fast path was used.
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+
+	readFrom bool
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func TestStatusRecorderReadFrom(t *testing.T) {
+	w := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+	http.ServeContent(recorder, httptest.NewRequest(http.MethodGet, "/maps/a.bsp", nil), "a.bsp", time.Time{}, strings.NewReader("map"))
+
+	if !w.readFrom {
+		t.Error("ServeContent did not use the ReadFrom of the underlying writer")
+	}
+
+	if got := w.Body.String(); got != "map" {
+		t.Errorf("body = %q, want %q", got, "map")
+	}
+
+	if recorder.status != http.StatusOK {
+		t.Errorf("status = %d, want %d", recorder.status, http.StatusOK)
+	}
+
+	if _, ok := any(recorder).(http.Flusher); !ok {
+		t.Error("statusRecorder does not implement http.Flusher")
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIntrusionThreshold   = 20
+	defaultIntrusionWindow      = 10 * time.Minute
+	defaultIntrusionForgetAfter = 24 * time.Hour
+)
+
+var defaultIntrusionBanDurations = []time.Duration{
+	5 * time.Minute,
+	30 * time.Minute,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+// Scores of suspicious requests.
+const (
+	intrusionScoreNotFound          = 1
+	intrusionScoreTooManyRequests   = 2
+	intrusionScoreDeniedExtension   = 5
+	intrusionScoreServerSidePath    = 5
+	intrusionScoreTraversalAttempts = 10
+)
+
+// Extensions of server-side files, clients never need them.
+var suspiciousExtensions = map[string]struct{}{
+	"amxx": {},
+	"cfg":  {},
+	"dll":  {},
+	"ini":  {},
+	"log":  {},
+	"php":  {},
+	"sma":  {},
+	"so":   {},
+	"sq3":  {},
+}
+
+// AutoBans are temporary bans issued by the intrusion detection.
+type AutoBans struct {
+	mu       sync.RWMutex
+	bans     map[netip.Addr]*autoBan
+	offences map[netip.Addr]*autoBanOffences
+}
+
+type autoBan struct {
+	Addr    netip.Addr
+	Reason  string
+	Expires time.Time
+}
+
+type autoBanOffences struct {
+	count int
+	last  time.Time
+}
+
+func NewAutoBans() *AutoBans {
+	return &AutoBans{
+		bans:     make(map[netip.Addr]*autoBan),
+		offences: make(map[netip.Addr]*autoBanOffences),
+	}
+}
+
+func (ab *AutoBans) Blocked(addr netip.Addr) bool {
+	ab.mu.RLock()
+	defer ab.mu.RUnlock()
+
+	ban, ok := ab.bans[unmapAddr(addr)]
+
+	return ok && time.Now().Before(ban.Expires)
+}
+
+// Ban bans the address, each next offence picks the next duration from durations.
+func (ab *AutoBans) Ban(addr netip.Addr, reason string, durations []time.Duration) time.Duration {
+	addr = unmapAddr(addr)
+	now := time.Now()
+
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	offences, ok := ab.offences[addr]
+	if !ok {
+		offences = &autoBanOffences{}
+		ab.offences[addr] = offences
+	}
+
+	duration := durations[min(offences.count, len(durations)-1)]
+
+	offences.count++
+	offences.last = now
+
+	ab.bans[addr] = &autoBan{
+		Addr:    addr,
+		Reason:  reason,
+		Expires: now.Add(duration),
+	}
+
+	return duration
+}
+
+// Unban lifts the ban and forgets previous offences.
+func (ab *AutoBans) Unban(addr netip.Addr) bool {
+	addr = unmapAddr(addr)
+
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	_, ok := ab.bans[addr]
+
+	delete(ab.bans, addr)
+	delete(ab.offences, addr)
+
+	return ok
+}
+
+// List returns active bans sorted by expiry.
+func (ab *AutoBans) List() []autoBan {
+	ab.mu.RLock()
+	defer ab.mu.RUnlock()
+
+	now := time.Now()
+	list := make([]autoBan, 0, len(ab.bans))
+
+	for _, ban := range ab.bans {
+		if now.Before(ban.Expires) {
+			list = append(list, *ban)
+		}
+	}
+
+	slices.SortFunc(list, func(a, b autoBan) int {
+		return a.Expires.Compare(b.Expires)
+	})
+
+	return list
+}
+
+func (ab *AutoBans) cleanup(now time.Time, forgetAfter time.Duration) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	for addr, ban := range ab.bans {
+		if !now.Before(ban.Expires) {
+			delete(ab.bans, addr)
+		}
+	}
+
+	for addr, offences := range ab.offences {
+		if now.Sub(offences.last) > forgetAfter {
+			delete(ab.offences, addr)
+		}
+	}
+}
+
+// IntrusionDetector scores suspicious requests per IP and bans IPs exceeding the threshold.
+type IntrusionDetector struct {
+	bans *AutoBans
+
+	threshold    int
+	window       time.Duration
+	banDurations []time.Duration
+
+	mu     sync.Mutex
+	scores map[netip.Addr]*intrusionScore
+}
+
+type intrusionScore struct {
+	value int
+	since time.Time
+}
+
+func NewIntrusionDetector(cfg ConfigIntrusionDetection, bans *AutoBans) *IntrusionDetector {
+	d := &IntrusionDetector{
+		bans:         bans,
+		threshold:    cfg.Threshold,
+		window:       cfg.Window.Duration(),
+		banDurations: make([]time.Duration, 0, len(cfg.BanDurations)),
+		scores:       make(map[netip.Addr]*intrusionScore),
+	}
+
+	if d.threshold <= 0 {
+		d.threshold = defaultIntrusionThreshold
+	}
+
+	if d.window <= 0 {
+		d.window = defaultIntrusionWindow
+	}
+
+	for _, duration := range cfg.BanDurations {
+		if duration.Duration() > 0 {
+			d.banDurations = append(d.banDurations, duration.Duration())
+		}
+	}
+
+	if len(d.banDurations) == 0 {
+		d.banDurations = defaultIntrusionBanDurations
+	}
+
+	return d
+}
+
+// Report adds score to the IP and bans it when the threshold is reached.
+func (d *IntrusionDetector) Report(addr netip.Addr, score int, reason string) {
+	addr = unmapAddr(addr)
+	now := time.Now()
+
+	d.mu.Lock()
+
+	s, ok := d.scores[addr]
+	if !ok || now.Sub(s.since) > d.window {
+		s = &intrusionScore{since: now}
+		d.scores[addr] = s
+	}
+
+	s.value += score
+	exceeded := s.value >= d.threshold
+
+	if exceeded {
+		delete(d.scores, addr)
+	}
+
+	d.mu.Unlock()
+
+	if !exceeded {
+		return
+	}
+
+	duration := d.bans.Ban(addr, reason, d.banDurations)
+
+	slog.Warn("IP temporarily banned",
+		"ip", addr.String(),
+		"reason", reason,
+		"duration", duration,
+	)
+}
+
+// Watch drops stale scores and expired bans until ctx is done.
+func (d *IntrusionDetector) Watch(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.mu.Lock()
+			for addr, s := range d.scores {
+				if now.Sub(s.since) > d.window {
+					delete(d.scores, addr)
+				}
+			}
+			d.mu.Unlock()
+
+			d.bans.cleanup(now, defaultIntrusionForgetAfter)
+		}
+	}
+}
+
+// inspectRequestPath scores the raw request URI before it is served.
+func inspectRequestPath(requestURI string) (int, string) {
+	rawPath, _, _ := strings.Cut(requestURI, "?")
+	lower := strings.NewReplacer("%2e", ".", "%2f", "/", "%5c", "/", "\\", "/").Replace(strings.ToLower(rawPath))
+
+	if strings.Contains(lower+"/", "/../") {
+		return intrusionScoreTraversalAttempts, "path traversal attempt"
+	}
+
+	for _, part := range strings.Split(lower, "/") {
+		if part == "addons" {
+			return intrusionScoreServerSidePath, "server-side path requested"
+		}
+	}
+
+	ext := strings.TrimPrefix(path.Ext(lower), ".")
+	if _, ok := suspiciousExtensions[ext]; ok {
+		return intrusionScoreDeniedExtension, "denied extension requested"
+	}
+
+	return 0, ""
+}
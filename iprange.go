@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var errInvalidIPRange = errors.New("invalid ip range")
+
+// IPRange is an inclusive range of addresses of the same family.
+type IPRange struct {
+	From netip.Addr
+	To   netip.Addr
+}
+
+// ParseIPRange parses a single IP, a CIDR prefix or an "a-b" range.
+func ParseIPRange(s string) (IPRange, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return IPRange{}, errors.WithMessage(err, "failed to parse cidr")
+		}
+
+		prefix = prefix.Masked()
+
+		return IPRange{
+			From: unmapAddr(prefix.Addr()),
+			To:   unmapAddr(lastAddr(prefix)),
+		}, nil
+	}
+
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		start, err := netip.ParseAddr(strings.TrimSpace(from))
+		if err != nil {
+			return IPRange{}, errors.WithMessage(err, "failed to parse range start")
+		}
+
+		end, err := netip.ParseAddr(strings.TrimSpace(to))
+		if err != nil {
+			return IPRange{}, errors.WithMessage(err, "failed to parse range end")
+		}
+
+		return NewIPRange(start, end)
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return IPRange{}, errors.WithMessage(err, "failed to parse ip")
+	}
+
+	addr = unmapAddr(addr)
+
+	return IPRange{From: addr, To: addr}, nil
+}
+
+// NewIPRange builds a range from two addresses of the same family.
+func NewIPRange(start, end netip.Addr) (IPRange, error) {
+	start = unmapAddr(start)
+	end = unmapAddr(end)
+
+	if start.Is4() != end.Is4() {
+		return IPRange{}, errors.WithMessage(errInvalidIPRange, "mixed address families")
+	}
+
+	if start.Compare(end) > 0 {
+		return IPRange{}, errors.WithMessage(errInvalidIPRange, "range start is greater than range end")
+	}
+
+	return IPRange{From: start, To: end}, nil
+}
+
+func (r IPRange) Contains(addr netip.Addr) bool {
+	addr = unmapAddr(addr)
+
+	return r.From.Compare(addr) <= 0 && addr.Compare(r.To) <= 0
+}
+
+func (r IPRange) String() string {
+	if r.From == r.To {
+		return r.From.String()
+	}
+
+	return r.From.String() + "-" + r.To.String()
+}
+
+// IPRangeSet is an immutable set of address ranges.
+// Ranges are kept sorted and merged, so a lookup is a binary search.
+type IPRangeSet struct {
+	ranges []IPRange
+}
+
+func NewIPRangeSet(ranges []IPRange) *IPRangeSet {
+	sorted := slices.Clone(ranges)
+
+	slices.SortFunc(sorted, func(a, b IPRange) int {
+		return a.From.Compare(b.From)
+	})
+
+	merged := make([]IPRange, 0, len(sorted))
+
+	for _, r := range sorted {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+
+			if last.From.Is4() == r.From.Is4() && canMerge(*last, r) {
+				if r.To.Compare(last.To) > 0 {
+					last.To = r.To
+				}
+
+				continue
+			}
+		}
+
+		merged = append(merged, r)
+	}
+
+	return &IPRangeSet{ranges: merged}
+}
+
+func (s *IPRangeSet) Contains(addr netip.Addr) bool {
+	if s == nil || len(s.ranges) == 0 || !addr.IsValid() {
+		return false
+	}
+
+	addr = unmapAddr(addr)
+
+	// Index of the first range starting after addr.
+	i, _ := slices.BinarySearchFunc(s.ranges, addr, func(r IPRange, a netip.Addr) int {
+		if r.From.Compare(a) <= 0 {
+			return -1
+		}
+
+		return 1
+	})
+
+	if i == 0 {
+		return false
+	}
+
+	return s.ranges[i-1].Contains(addr)
+}
+
+// Len returns the number of ranges after merging.
+func (s *IPRangeSet) Len() int {
+	if s == nil {
+		return 0
+	}
+
+	return len(s.ranges)
+}
+
+// canMerge reports whether b overlaps or directly follows a. The ranges must be sorted.
+func canMerge(a, b IPRange) bool {
+	if b.From.Compare(a.To) <= 0 {
+		return true
+	}
+
+	next := a.To.Next()
+
+	return next.IsValid() && next == b.From
+}
+
+func unmapAddr(addr netip.Addr) netip.Addr {
+	return addr.Unmap().WithZone("")
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	bits := prefix.Bits()
+
+	for i := range bytes {
+		hostBits := bits - i*8
+
+		switch {
+		case hostBits <= 0:
+			bytes[i] = 0xff
+		case hostBits < 8:
+			bytes[i] |= 0xff >> hostBits
+		}
+	}
+
+	addr, _ := netip.AddrFromSlice(bytes)
+
+	return addr
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseIPRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "single ipv4", in: "10.0.0.1", want: "10.0.0.1"},
+		{name: "single ipv6", in: "2001:db8::1", want: "2001:db8::1"},
+		{name: "ipv4-mapped ipv6 is unmapped", in: "::ffff:10.0.0.1", want: "10.0.0.1"},
+		{name: "zone is dropped", in: "fe80::1%eth0", want: "fe80::1"},
+		{name: "spaces are trimmed", in: " 10.0.0.1 ", want: "10.0.0.1"},
+		{name: "ipv4 cidr", in: "10.0.0.0/24", want: "10.0.0.0-10.0.0.255"},
+		{name: "ipv4 cidr is masked", in: "10.0.0.77/24", want: "10.0.0.0-10.0.0.255"},
+		{name: "ipv4 /0", in: "0.0.0.0/0", want: "0.0.0.0-255.255.255.255"},
+		{name: "ipv4 /32", in: "10.0.0.1/32", want: "10.0.0.1"},
+		{name: "ipv6 /0", in: "::/0", want: "::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{name: "ipv6 /128", in: "2001:db8::1/128", want: "2001:db8::1"},
+		{name: "ipv6 /127", in: "2001:db8::/127", want: "2001:db8::-2001:db8::1"},
+		{name: "ipv4-mapped cidr", in: "::ffff:10.0.0.0/120", want: "10.0.0.0-10.0.0.255"},
+		{name: "range", in: "10.0.0.1-10.0.0.9", want: "10.0.0.1-10.0.0.9"},
+		{name: "range with spaces", in: "10.0.0.1 - 10.0.0.9", want: "10.0.0.1-10.0.0.9"},
+		{name: "range of one address", in: "10.0.0.1-10.0.0.1", want: "10.0.0.1"},
+		{name: "ipv6 range", in: "2001:db8::1-2001:db8::ff", want: "2001:db8::1-2001:db8::ff"},
+		{name: "mapped range start", in: "::ffff:10.0.0.1-10.0.0.9", want: "10.0.0.1-10.0.0.9"},
+		{name: "reversed range", in: "10.0.0.9-10.0.0.1", wantErr: true},
+		{name: "reversed ipv6 range", in: "2001:db8::ff-2001:db8::1", wantErr: true},
+		{name: "mixed families", in: "10.0.0.1-2001:db8::1", wantErr: true},
+		{name: "invalid ip", in: "10.0.0.256", wantErr: true},
+		{name: "invalid cidr", in: "10.0.0.0/33", wantErr: true},
+		{name: "invalid range end", in: "10.0.0.1-x", wantErr: true},
+		{name: "empty", in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseIPRange(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseIPRange(%q) = %s, want error", tt.in, r)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseIPRange(%q) error: %v", tt.in, err)
+			}
+
+			if got := r.String(); got != tt.want {
+				t.Errorf("ParseIPRange(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewIPRangeSetMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []string
+		want   []string
+	}{
+		{
+			name:   "adjacent ipv4",
+			ranges: []string{"10.0.0.0-10.0.0.9", "10.0.0.10-10.0.0.20"},
+			want:   []string{"10.0.0.0-10.0.0.20"},
+		},
+		{
+			name:   "adjacent across an octet",
+			ranges: []string{"10.0.0.0/24", "10.0.1.0/24"},
+			want:   []string{"10.0.0.0-10.0.1.255"},
+		},
+		{
+			name:   "gap of one address",
+			ranges: []string{"10.0.0.0-10.0.0.9", "10.0.0.11-10.0.0.20"},
+			want:   []string{"10.0.0.0-10.0.0.9", "10.0.0.11-10.0.0.20"},
+		},
+		{
+			name:   "overlapping",
+			ranges: []string{"10.0.0.0-10.0.0.15", "10.0.0.10-10.0.0.20"},
+			want:   []string{"10.0.0.0-10.0.0.20"},
+		},
+		{
+			name:   "contained",
+			ranges: []string{"10.0.0.0/16", "10.0.5.0/24", "10.0.0.1"},
+			want:   []string{"10.0.0.0-10.0.255.255"},
+		},
+		{
+			name:   "unsorted input",
+			ranges: []string{"10.0.0.20", "10.0.0.18-10.0.0.19", "10.0.0.17"},
+			want:   []string{"10.0.0.17-10.0.0.20"},
+		},
+		{
+			name:   "duplicates",
+			ranges: []string{"10.0.0.1", "10.0.0.1"},
+			want:   []string{"10.0.0.1"},
+		},
+		{
+			name:   "adjacent ipv6",
+			ranges: []string{"2001:db8::-2001:db8::ff", "2001:db8::100-2001:db8::1ff"},
+			want:   []string{"2001:db8::-2001:db8::1ff"},
+		},
+		{
+			name:   "mixed families are not merged",
+			ranges: []string{"0.0.0.0/0", "::/0"},
+			want:   []string{"0.0.0.0-255.255.255.255", "::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+		},
+		{
+			name:   "last ipv4 address does not merge with the first ipv6 address",
+			ranges: []string{"255.255.255.255", "::"},
+			want:   []string{"255.255.255.255", "::"},
+		},
+		{
+			name:   "ipv4-mapped merges with ipv4",
+			ranges: []string{"::ffff:10.0.0.0/120", "10.0.1.0/24"},
+			want:   []string{"10.0.0.0-10.0.1.255"},
+		},
+		{
+			name:   "empty",
+			ranges: nil,
+			want:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := NewIPRangeSet(mustParseIPRanges(t, tt.ranges...))
+
+			got := make([]string, 0, set.Len())
+			for _, r := range set.ranges {
+				got = append(got, r.String())
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("merged ranges = %v, want %v", got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("merged ranges = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestIPRangeSetContains(t *testing.T) {
+	set := NewIPRangeSet(mustParseIPRanges(t,
+		"10.0.0.10-10.0.0.20",
+		"10.0.0.30",
+		"192.168.0.0/16",
+		"2001:db8::10-2001:db8::20",
+		"2001:db8:1::/128",
+	))
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "10.0.0.9", want: false},
+		{addr: "10.0.0.10", want: true},
+		{addr: "10.0.0.15", want: true},
+		{addr: "10.0.0.20", want: true},
+		{addr: "10.0.0.21", want: false},
+		{addr: "10.0.0.29", want: false},
+		{addr: "10.0.0.30", want: true},
+		{addr: "10.0.0.31", want: false},
+		{addr: "192.167.255.255", want: false},
+		{addr: "192.168.0.0", want: true},
+		{addr: "192.168.255.255", want: true},
+		{addr: "192.169.0.0", want: false},
+		{addr: "0.0.0.0", want: false},
+		{addr: "255.255.255.255", want: false},
+		{addr: "::ffff:10.0.0.10", want: true},
+		{addr: "::ffff:10.0.0.21", want: false},
+		{addr: "::ffff:192.168.1.1", want: true},
+		{addr: "2001:db8::f", want: false},
+		{addr: "2001:db8::10", want: true},
+		{addr: "2001:db8::20", want: true},
+		{addr: "2001:db8::21", want: false},
+		{addr: "2001:db8:1::", want: true},
+		{addr: "2001:db8:1::1", want: false},
+		{addr: "2001:db8::10%eth0", want: true},
+		{addr: "::a00:a", want: false},
+		{addr: "::", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := set.Contains(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("Contains(%s) = %t, want %t", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPRangeSetContainsWholeFamily(t *testing.T) {
+	tests := []struct {
+		name  string
+		rng   string
+		addrs []string
+		want  bool
+	}{
+		{name: "ipv4 /0 matches ipv4", rng: "0.0.0.0/0", addrs: []string{"0.0.0.0", "10.0.0.1", "255.255.255.255", "::ffff:1.2.3.4"}, want: true},
+		{name: "ipv4 /0 does not match ipv6", rng: "0.0.0.0/0", addrs: []string{"::", "2001:db8::1", "::1"}, want: false},
+		{name: "ipv6 /0 matches ipv6", rng: "::/0", addrs: []string{"::", "2001:db8::1", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"}, want: true},
+		{name: "ipv6 /0 does not match ipv4", rng: "::/0", addrs: []string{"0.0.0.0", "10.0.0.1", "::ffff:10.0.0.1"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := NewIPRangeSet(mustParseIPRanges(t, tt.rng))
+
+			for _, addr := range tt.addrs {
+				if got := set.Contains(netip.MustParseAddr(addr)); got != tt.want {
+					t.Errorf("Contains(%s) = %t, want %t", addr, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestIPRangeSetContainsEmpty(t *testing.T) {
+	var nilSet *IPRangeSet
+
+	if nilSet.Contains(netip.MustParseAddr("10.0.0.1")) {
+		t.Error("nil set contains an address")
+	}
+
+	set := NewIPRangeSet(mustParseIPRanges(t, "0.0.0.0/0"))
+	if set.Contains(netip.Addr{}) {
+		t.Error("set contains the zero address")
+	}
+}
+
+func mustParseIPRanges(t *testing.T, values ...string) []IPRange {
+	t.Helper()
+
+	ranges := make([]IPRange, 0, len(values))
+
+	for _, value := range values {
+		r, err := ParseIPRange(value)
+		if err != nil {
+			t.Fatalf("ParseIPRange(%q) error: %v", value, err)
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
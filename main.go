@@ -9,7 +9,6 @@ import (
 	"net"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
 )
 
@@ -33,6 +32,7 @@ func init() {
 
 	err = metamod.SetMetaCallbacks(&metamod.MetaCallbacks{
 		MetaQuery:  metaQueryFn(plugin),
+		MetaAttach: metaAttachFn(plugin),
 		MetaDetach: metaDetachFn(plugin),
 	})
 	if err != nil {
@@ -64,12 +64,53 @@ func init() {
 
 			return metamod.APICallbackResultHandled
 		},
+		ClientConnect: func(e *metamod.Edict, name string, address string) (metamod.APICallbackResult, bool, string) {
+			engineFuncs, err := metamod.GetEngineFuncs()
+			if err != nil {
+				slog.Error("Failed to get engine funcs: ", "error", err)
+
+				return metamod.APICallbackResultIgnored, true, ""
+			}
+
+			if plugin.clients.Connect(engineFuncs.IndexOfEdict(e), address) {
+				slog.Debug("Client connecting", "name", name, "address", address)
+			}
+
+			return metamod.APICallbackResultHandled, true, ""
+		},
+		ClientDisconnect: func(e *metamod.Edict) metamod.APICallbackResult {
+			engineFuncs, err := metamod.GetEngineFuncs()
+			if err != nil {
+				slog.Error("Failed to get engine funcs: ", "error", err)
+
+				return metamod.APICallbackResultIgnored
+			}
+
+			plugin.clients.Disconnect(engineFuncs.IndexOfEdict(e))
+
+			return metamod.APICallbackResultHandled
+		},
+		StartFrame: func() metamod.APICallbackResult {
+			engineFuncs, err := metamod.GetEngineFuncs()
+			if err != nil {
+				return metamod.APICallbackResultIgnored
+			}
+
+			plugin.console.Flush(engineFuncs)
+
+			if plugin.Config() == nil {
+				return metamod.APICallbackResultIgnored
+			}
+
+			plugin.pollCVars(engineFuncs)
+
+			return metamod.APICallbackResultHandled
+		},
 		ServerActivate: func(_ *metamod.Edict, _ int, _ int) metamod.APICallbackResult {
 			slog.Debug("Server activated")
 
-			if plugin.cfg.ServePrecached {
-				processMapRelatedResource(plugin)
-			}
+			processMapRelatedResource(plugin)
+			writeGameBans(plugin)
 
 			return metamod.APICallbackResultHandled
 		},
@@ -95,7 +136,7 @@ func init() {
 			return metamod.EngineHookResultHandled, 0
 		},
 		PrecacheSound: func(soundPath string) (metamod.EngineHookResult, int) {
-			fullPath := filepath.Join("sound", soundPath)
+			fullPath := NormalizeSoundPath(soundPath)
 
 			slog.Debug("Precaching sound", "filePath", fullPath)
 
@@ -103,6 +144,11 @@ func init() {
 
 			return metamod.EngineHookResultHandled, 0
 		},
+		ServerCommand: func(str string) metamod.EngineHookResult {
+			plugin.gameBans.HandleServerCommand(str)
+
+			return metamod.EngineHookResultHandled
+		},
 	})
 }
 
@@ -148,6 +194,7 @@ func metaQueryFn(p *Plugin) func() int {
 		)
 
 		gameDir := engineFuncs.GetGameDir()
+		p.SetGameDir(gameDir)
 
 		cfg, err := loadConfig(gameDir)
 		if err != nil {
@@ -159,54 +206,46 @@ func metaQueryFn(p *Plugin) func() int {
 			return 0
 		}
 
-		if cfg.Host == "" {
-			ip := engineFuncs.CVarGetString("ip")
-			cfg.Host = ip
-		}
-
-		if (cfg.Host == "" || cfg.Host == "0.0.0.0") && cfg.CustomDownloadURL == "" {
-			panic("host is not set, please set host or customDownloadURL in config")
+		if err := resolveAddresses(cfg, engineFuncs.CVarGetString("ip")); err != nil {
+			slog.Error("Failed to determine the public host, sv_downloadurl is not set", "error", err)
 		}
 
 		if cfg.Port == 0 {
 			err = setRandomPort(cfg)
 			if err != nil {
-				panic(err)
+				slog.Error("Failed to choose a random port", "error", err)
+
+				return 0
 			}
 		}
 
+		slog.Info("Effective config", "config", cfg)
+
 		p.SetConfig(cfg)
-		p.SetGameDir(gameDir)
 
-		go func() {
-			runtime.LockOSThread()
+		if err := p.RunServer(gameDir); err != nil {
+			slog.Error("Failed to start FastDL server, the plugin is inactive", "error", err)
 
-			err := p.RunServer(gameDir)
-			if err != nil {
-				panic(err)
-			}
-		}()
+			return 0
+		}
 
-		var svDownloadUrl string
+		p.updateDownloadURL(engineFuncs)
 
-		if cfg.CustomDownloadURL != "" {
-			svDownloadUrl = cfg.CustomDownloadURL
-		} else {
-			svDownloadUrl = fmt.Sprintf("http://%s:%d", cfg.Host, cfg.Port)
-		}
+		return 1
+	}
+}
 
-		slog.Info(
-			"Changing sv_downloadurl",
-			"sv_downloadurl", svDownloadUrl,
-		)
+func metaAttachFn(p *Plugin) func(now int) int {
+	return func(now int) int {
+		engineFuncs, err := metamod.GetEngineFuncs()
+		if err != nil {
+			slog.Error("Failed to get engine funcs: ", "error", err)
 
-		engineFuncs.ServerCommand(
-			fmt.Sprintf(
-				"sv_downloadurl \"%s\"",
-				svDownloadUrl,
-			),
-		)
-		engineFuncs.ServerExecute()
+			return 0
+		}
+
+		registerServerCommands(engineFuncs, p)
+		registerCVars(engineFuncs)
 
 		return 1
 	}
@@ -226,7 +265,8 @@ func metaDetachFn(p *Plugin) func(now int, reason int) int {
 	}
 }
 
-func loadConfig(gameDir string) (*Config, error) {
+// findConfigFile returns the path of the config file or an empty string if there is none.
+func findConfigFile(gameDir string) string {
 	fileNames := []string{
 		"fastdl.yml",
 		"fastdl.yaml",
@@ -237,50 +277,92 @@ func loadConfig(gameDir string) (*Config, error) {
 		filepath.Join(gameDir, "addons", "fastdl"),
 	}
 
-	var files []string
 	for _, dir := range dirs {
 		for _, fileName := range fileNames {
-			files = append(files, filepath.Join(dir, fileName))
+			file := filepath.Join(dir, fileName)
+
+			if _, err := os.Stat(file); err == nil {
+				return file
+			}
 		}
 	}
 
-	for _, file := range files {
-		if _, err := os.Stat(file); err == nil {
-			configContents, err := os.ReadFile(file)
-			if err != nil {
-				return nil, errors.WithMessage(err, "failed to read config file")
-			}
+	return ""
+}
 
-			cfg, err := ParseConfig(configContents)
-			if err != nil {
-				return nil, errors.WithMessage(err, "failed to parse config file")
-			}
+// checkConfigFile reads and validates the config file.
+// Without the file, the defaults with the mod profile and environment overrides are validated.
+func checkConfigFile(gameDir, file string) (*Config, ConfigIssues, error) {
+	if file == "" {
+		cfg, issues := ValidateConfig(gameDir, envConfigFile, nil)
+
+		return cfg, issues, nil
+	}
+
+	configContents, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed to read config file")
+	}
+
+	cfg, issues := ValidateConfig(gameDir, file, configContents)
+
+	return cfg, issues, nil
+}
+
+func loadConfig(gameDir string) (*Config, error) {
+	file := findConfigFile(gameDir)
+	if file == "" {
+		slog.Info("Config file not found, using defaults")
+	}
 
-			return cfg, nil
+	cfg, issues, err := checkConfigFile(gameDir, file)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, issue := range issues {
+		if issue.Severity == configSeverityError {
+			slog.Error("Invalid config", "file", issue.File, "line", issue.Line, "error", issue.Message)
+		} else {
+			slog.Warn("Config warning", "file", issue.File, "line", issue.Line, "warning", issue.Message)
 		}
 	}
 
-	return DefaultConfig, nil
+	if issues.HasErrors() {
+		return nil, errors.Errorf("config has %d errors", issues.Count(configSeverityError))
+	}
+
+	if file != "" {
+		slog.Info("Config loaded", "file", file)
+	}
+
+	return cfg, nil
 }
 
+// randomPortAttempts limits the ports tried from the port range before giving up.
+const randomPortAttempts = 100
+
+// setRandomPort sets a free port from the port range, or a port chosen by the system if the range is not set.
 func setRandomPort(cfg *Config) error {
-	randomPort := 0
 	minPort, maxPort := cfg.PortRange.IntRange()
 
 	var listener net.Listener
 	var err error
 
-	for {
+	for range randomPortAttempts {
+		randomPort := 0
 		if maxPort > 0 && minPort < maxPort {
 			randomPort = rand.Intn(maxPort-minPort+1) + minPort
 		}
 
-		listener, err = net.Listen("tcp", fmt.Sprintf(":%d", randomPort))
-		if err != nil {
-			continue
+		listener, err = net.Listen("tcp", listenAddress(cfg.BindAddress, uint16(randomPort)))
+		if err == nil || randomPort == 0 {
+			break
 		}
+	}
 
-		break
+	if err != nil {
+		return errors.Wrapf(err, "no free port found on %q", cfg.BindAddress)
 	}
 
 	cfg.Port = uint16(listener.Addr().(*net.TCPAddr).Port)
@@ -317,31 +399,74 @@ func processMapRelatedResource(p *Plugin) {
 
 	mapName := strings.TrimSuffix(filepath.Base(mapPath), filepath.Ext(mapPath))
 
-	p.AppendPrecached(filepath.Join("overviews", fmt.Sprintf("%s.txt", mapName)))
-	p.AppendPrecached(filepath.Join("overviews", fmt.Sprintf("%s.bmp", mapName)))
-	p.AppendPrecached(filepath.Join("overviews", fmt.Sprintf("%s.tga", mapName)))
-
-	p.AppendPrecached(filepath.Join("maps", fmt.Sprintf("%s.txt", mapName)))
-	p.AppendPrecached(filepath.Join("maps", fmt.Sprintf("%s_detail.txt", mapName)))
+	p.ActivateMap(mapName)
 
-	// Append sky
 	skyName := engineFuncs.CVarGetString("sv_skyname")
 
-	if skyName != "" {
-		p.AppendPrecached(filepath.Join("gfx", "env", fmt.Sprintf("%sbk.tga", skyName)))
-		p.AppendPrecached(filepath.Join("gfx", "env", fmt.Sprintf("%sdn.tga", skyName)))
-		p.AppendPrecached(filepath.Join("gfx", "env", fmt.Sprintf("%sft.tga", skyName)))
-		p.AppendPrecached(filepath.Join("gfx", "env", fmt.Sprintf("%slf.tga", skyName)))
-		p.AppendPrecached(filepath.Join("gfx", "env", fmt.Sprintf("%srt.tga", skyName)))
-		p.AppendPrecached(filepath.Join("gfx", "env", fmt.Sprintf("%sup.tga", skyName)))
-
-		p.AppendPrecached(filepath.Join("gfx", "env", fmt.Sprintf("%sbk.bmp", skyName)))
-		p.AppendPrecached(filepath.Join("gfx", "env", fmt.Sprintf("%sdn.bmp", skyName)))
-		p.AppendPrecached(filepath.Join("gfx", "env", fmt.Sprintf("%sft.bmp", skyName)))
-		p.AppendPrecached(filepath.Join("gfx", "env", fmt.Sprintf("%slf.bmp", skyName)))
-		p.AppendPrecached(filepath.Join("gfx", "env", fmt.Sprintf("%srt.bmp", skyName)))
-		p.AppendPrecached(filepath.Join("gfx", "env", fmt.Sprintf("%sup.bmp", skyName)))
+	for _, template := range p.Config().MapResourceTemplates() {
+		for _, resource := range expandMapResource(template, mapName, skyName) {
+			p.AppendPrecached(resource)
+		}
+	}
+}
+
+// downloadURL returns sv_downloadurl for the config, empty if the plugin is disabled.
+func downloadURL(cfg *Config) string {
+	if !cfg.Enabled {
+		return ""
+	}
+
+	if cfg.CustomDownloadURL != "" {
+		return cfg.CustomDownloadURL
+	}
+
+	if cfg.PublicHost == "" {
+		return ""
 	}
+
+	return "http://" + listenAddress(cfg.PublicHost, cfg.Port)
+}
+
+// updateDownloadURL changes sv_downloadurl if the download URL of the config changed.
+// writeGameBans writes the game server IP filter list to listip.cfg, the game bans watcher picks it up.
+// Bans issued from the console or rcon are not seen by the plugin otherwise.
+func writeGameBans(p *Plugin) {
+	cfg := p.Config()
+	if cfg == nil || !cfg.ShareGameBans {
+		return
+	}
+
+	engineFuncs, err := metamod.GetEngineFuncs()
+	if err != nil {
+		slog.Error("Failed to get engine funcs: ", "error", err)
+
+		return
+	}
+
+	engineFuncs.ServerCommand("writeip")
+	engineFuncs.ServerExecute()
+}
+
+func (p *Plugin) updateDownloadURL(engineFuncs *metamod.EngineFuncs) {
+	svDownloadUrl := downloadURL(p.Config())
+	if svDownloadUrl == p.downloadURL {
+		return
+	}
+
+	p.downloadURL = svDownloadUrl
+
+	slog.Info(
+		"Changing sv_downloadurl",
+		"sv_downloadurl", svDownloadUrl,
+	)
+
+	engineFuncs.ServerCommand(
+		fmt.Sprintf(
+			"sv_downloadurl \"%s\"",
+			svDownloadUrl,
+		),
+	)
+	engineFuncs.ServerExecute()
 }
 
 func main() {}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestSetRandomPortGivesUp(t *testing.T) {
+	var listeners []net.Listener
+
+	defer func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}()
+
+	// Occupy two consecutive ports for the range.
+	var port int
+
+	for len(listeners) < 2 {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+
+		listeners = listeners[:0]
+
+		first, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listeners = append(listeners, first)
+		port = first.Addr().(*net.TCPAddr).Port
+
+		second, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port+1))
+		if err == nil {
+			listeners = append(listeners, second)
+		}
+	}
+
+	cfg := &Config{
+		BindAddress: "127.0.0.1",
+		PortRange:   ConfigPortRange(fmt.Sprintf("%d-%d", port, port+1)),
+	}
+
+	if err := setRandomPort(cfg); err == nil {
+		t.Fatalf("setRandomPort chose busy port %d", cfg.Port)
+	}
+}
+
+func TestSetRandomPortFromRange(t *testing.T) {
+	cfg := &Config{
+		BindAddress: "127.0.0.1",
+		PortRange:   "40000-40100",
+	}
+
+	if err := setRandomPort(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Port < 40000 || cfg.Port > 40100 {
+		t.Errorf("port %d is outside the range", cfg.Port)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigMaps are per-map overrides keyed by a map name or a glob like "surf_*".
+// All sections matching the map are applied in the file order.
+type ConfigMaps []ConfigMapSection
+
+type ConfigMapSection struct {
+	Pattern string
+	node    *yaml.Node
+}
+
+// ConfigMapOverride lists options a map section can override.
+// List options support the same replace and append operations as the top-level options.
+type ConfigMapOverride struct {
+	AllowedPaths      ConfigList[string] `yaml:"allowedPaths"`
+	AllowedExtensions ConfigList[string] `yaml:"allowedExtensions"`
+	Rules             ConfigList[string] `yaml:"rules"`
+	ServePrecached    *bool              `yaml:"servePrecached"`
+	Resources         ConfigList[string] `yaml:"resources"`
+}
+
+func (m *ConfigMaps) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return errors.Errorf("line %d: maps must be a mapping of map names to options", value.Line)
+	}
+
+	sections := make(ConfigMaps, 0, len(value.Content)/2)
+
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		section := ConfigMapSection{
+			Pattern: value.Content[i].Value,
+			node:    value.Content[i+1],
+		}
+
+		// Report type errors on load rather than on map change.
+		if _, err := section.Override(); err != nil {
+			return err
+		}
+
+		sections = append(sections, section)
+	}
+
+	*m = sections
+
+	return nil
+}
+
+func (m ConfigMaps) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+
+	for _, section := range m {
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: section.Pattern},
+			section.node,
+		)
+	}
+
+	return node, nil
+}
+
+// Override returns options of the section on their own, without a base config.
+func (s ConfigMapSection) Override() (ConfigMapOverride, error) {
+	var override ConfigMapOverride
+
+	err := s.node.Decode(&override)
+
+	return override, err
+}
+
+// Matches reports whether the section applies to the map, names are case-insensitive.
+func (s ConfigMapSection) Matches(mapName string) bool {
+	matched, err := path.Match(strings.ToLower(s.Pattern), strings.ToLower(mapName))
+
+	return err == nil && matched
+}
+
+// ApplyMap applies sections matching the map on top of the config.
+func (c *Config) ApplyMap(mapName string) error {
+	for _, section := range c.Maps {
+		if !section.Matches(mapName) {
+			continue
+		}
+
+		override := ConfigMapOverride{
+			AllowedPaths:      c.AllowedPaths,
+			AllowedExtensions: c.AllowedExtensions,
+			Rules:             c.Rules,
+			ServePrecached:    &c.ServePrecached,
+			Resources:         c.mapResources,
+		}
+
+		if err := section.node.Decode(&override); err != nil {
+			return errors.WithMessagef(err, "failed to apply maps section %q", section.Pattern)
+		}
+
+		c.AllowedPaths = override.AllowedPaths
+		c.AllowedExtensions = override.AllowedExtensions
+		c.Rules = override.Rules
+		c.mapResources = override.Resources
+	}
+
+	return nil
+}
+
+// WithMap returns a copy of the config with the sections matching the map applied.
+func (c *Config) WithMap(mapName string) (*Config, error) {
+	cfg := *c
+
+	if mapName == "" {
+		return &cfg, nil
+	}
+
+	if err := cfg.ApplyMap(mapName); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// MapResourceTemplates returns resource templates of the current map: the mapResources option
+// and extra resources from the maps sections.
+func (c *Config) MapResourceTemplates() []string {
+	return slices.Concat(c.MapResources, c.mapResources)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	profileAuto = "auto"
+	profileNone = "none"
+
+	liblistFileName = "liblist.gam"
+)
+
+// Placeholders of map resource templates.
+const (
+	mapResourceMap  = "{map}"
+	mapResourceSky  = "{sky}"
+	mapResourceSide = "{side}"
+)
+
+var skySides = []string{"bk", "dn", "ft", "lf", "rt", "up"}
+
+// modProfiles are applied on top of the defaults before environment variables and the config file.
+// The defaults cover the engine conventions shared by all mods: WADs, detail textures and skies.
+// Half-Life and Opposing Force need nothing else, so they have no profile.
+var modProfiles = map[string]string{
+	// Map briefings and spectator overviews.
+	"cstrike": `
+mapResources:
+  append:
+    - maps/{map}.txt
+    - overviews/{map}.txt
+    - overviews/{map}.bmp
+`,
+	"czero": `
+mapResources:
+  append:
+    - maps/{map}.txt
+    - overviews/{map}.txt
+    - overviews/{map}.bmp
+`,
+	// Day of Defeat overviews are made both as BMP and TGA.
+	"dod": `
+mapResources:
+  append:
+    - maps/{map}.txt
+    - overviews/{map}.txt
+    - overviews/{map}.bmp
+    - overviews/{map}.tga
+`,
+	"tfc": `
+mapResources:
+  append:
+    - maps/{map}.txt
+    - overviews/{map}.txt
+    - overviews/{map}.bmp
+`,
+	// Sven Co-op map scripts are served from scripts/maps only, server plugins are in scripts/plugins.
+	"svencoop": `
+allowedExtensions:
+  append: [as, ogg]
+allowedPaths:
+  append: [scripts/maps]
+`,
+}
+
+// DetectMod returns the profile name for the game directory.
+// Mods without a profile use the profile of their fallback_dir from liblist.gam,
+// an empty name means the defaults are used as is.
+func DetectMod(gameDir string) string {
+	mod := strings.ToLower(filepath.Base(gameDir))
+	if _, ok := modProfiles[mod]; ok {
+		return mod
+	}
+
+	liblist, err := readLiblist(gameDir)
+	if err == nil {
+		fallback := strings.ToLower(liblist["fallback_dir"])
+		if _, ok := modProfiles[fallback]; ok {
+			return fallback
+		}
+	}
+
+	return ""
+}
+
+// readLiblist reads key "value" pairs from liblist.gam of the game directory.
+func readLiblist(gameDir string) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(gameDir, liblistFileName))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to open liblist.gam")
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+
+		values[strings.ToLower(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithMessage(err, "failed to read liblist.gam")
+	}
+
+	return values, nil
+}
+
+// applyModProfile applies the profile to the config. An empty name or "auto" detects the mod.
+func applyModProfile(cfg *Config, gameDir, name string) (string, error) {
+	switch name {
+	case profileNone:
+		return "", nil
+	case "", profileAuto:
+		name = DetectMod(gameDir)
+		if name == "" {
+			return "", nil
+		}
+	}
+
+	profile, ok := modProfiles[name]
+	if !ok {
+		return "", errors.Errorf("unknown profile %q", name)
+	}
+
+	if _, err := ParseConfig(cfg, []byte(profile)); err != nil {
+		return "", errors.WithMessagef(err, "invalid profile %q", name)
+	}
+
+	return name, nil
+}
+
+// expandMapResource expands placeholders in the map resource template.
+// Templates with {sky} are skipped if the map has no sky name, {side} expands to the six sky sides.
+func expandMapResource(template, mapName, skyName string) []string {
+	resource := strings.ReplaceAll(template, mapResourceMap, mapName)
+
+	if strings.Contains(resource, mapResourceSky) {
+		if skyName == "" {
+			return nil
+		}
+
+		resource = strings.ReplaceAll(resource, mapResourceSky, skyName)
+	}
+
+	if !strings.Contains(resource, mapResourceSide) {
+		return []string{resource}
+	}
+
+	resources := make([]string, 0, len(skySides))
+	for _, side := range skySides {
+		resources = append(resources, strings.ReplaceAll(resource, mapResourceSide, side))
+	}
+
+	return resources
+}
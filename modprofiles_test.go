@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestApplyModProfile(t *testing.T) {
+	root := t.TempDir()
+
+	mod := filepath.Join(root, "mymod")
+	if err := os.MkdirAll(mod, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(mod, liblistFileName), []byte("game \"My Mod\"\nfallback_dir \"dod\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		gameDir      string
+		profile      string
+		wantProfile  string
+		wantResource string
+		wantErr      bool
+	}{
+		{name: "detected by directory", gameDir: filepath.Join(root, "cstrike"), wantProfile: "cstrike", wantResource: "overviews/{map}.bmp"},
+		{name: "detected by fallback_dir", gameDir: mod, profile: profileAuto, wantProfile: "dod", wantResource: "overviews/{map}.tga"},
+		{name: "no profile", gameDir: filepath.Join(root, "valve"), wantProfile: ""},
+		{name: "none", gameDir: filepath.Join(root, "cstrike"), profile: profileNone, wantProfile: ""},
+		{name: "explicit", gameDir: filepath.Join(root, "valve"), profile: "tfc", wantProfile: "tfc", wantResource: "maps/{map}.txt"},
+		{name: "unknown", gameDir: filepath.Join(root, "cstrike"), profile: "valve", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+
+			got, err := applyModProfile(cfg, tt.gameDir, tt.profile)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("applyModProfile(%q) = %q, want error", tt.profile, got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("applyModProfile(%q) error: %v", tt.profile, err)
+			}
+
+			if got != tt.wantProfile {
+				t.Errorf("applyModProfile(%q) = %q, want %q", tt.profile, got, tt.wantProfile)
+			}
+
+			if !slices.Contains(cfg.MapResources, "maps/{map}_detail.txt") {
+				t.Errorf("default map resources are dropped: %v", cfg.MapResources)
+			}
+
+			if tt.wantResource != "" && !slices.Contains(cfg.MapResources, tt.wantResource) {
+				t.Errorf("map resources %v do not contain %q", cfg.MapResources, tt.wantResource)
+			}
+
+			if tt.wantProfile == "" && len(cfg.MapResources) != len(DefaultConfig().MapResources) {
+				t.Errorf("map resources %v differ from the defaults", cfg.MapResources)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// soundNamePrefixes are flags the engine strips from sound names: "*" streams the sound,
+// "!" refers to a sentence and "#" is used by some mods for the same purpose.
+const soundNamePrefixes = "*!#"
+
+// NormalizePath converts a precached name or a request path to a name relative to the game directory:
+// backslashes become slashes, duplicate slashes and "." components are removed.
+// Precached names and requests are normalized the same way, so they match in servePrecached mode.
+func NormalizePath(name string) string {
+	return gameFSName(strings.ReplaceAll(name, `\`, "/"))
+}
+
+// NormalizeSoundPath converts a sound name from PrecacheSound to a path.
+func NormalizeSoundPath(soundName string) string {
+	soundName = strings.ReplaceAll(soundName, `\`, "/")
+	soundName = strings.TrimLeft(strings.TrimLeft(soundName, "/"), soundNamePrefixes)
+
+	return NormalizePath(path.Join("sound", soundName))
+}
+
+// requestNameCandidates returns the names a request path can refer to, the normalized path first.
+// Some clients encode spaces as "+" or encode the path twice, the other candidates decode them.
+func requestNameCandidates(urlPath string) []string {
+	name := NormalizePath(urlPath)
+	candidates := []string{name}
+
+	if strings.Contains(name, "+") {
+		candidates = append(candidates, strings.ReplaceAll(name, "+", " "))
+	}
+
+	if strings.Contains(name, "%") {
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			candidates = append(candidates, NormalizePath(unescaped))
+		}
+	}
+
+	return candidates
+}
@@ -5,25 +5,75 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"log/slog"
+	"net"
 	"net/http"
-	"path/filepath"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Plugin struct {
-	cfg     *Config
+	cfg     atomic.Pointer[Config]
 	gameDir string
 
-	server *http.Server
+	serverMu   sync.Mutex
+	server     *http.Server
+	serverCtx  context.Context
+	stopServer context.CancelFunc
+	handlers   reloadableHandler
 
-	precachedFiles *map[string]struct{}
+	// reloadMu serializes reloads, it is held while the handler chain is built.
+	reloadMu sync.Mutex
+
+	// stateMu guards the state shared with the game thread, it is never held for long.
+	stateMu    sync.Mutex
+	configFile configFileState
+	mapName    string
+	cvars      map[string]string
+
+	// The config without the overrides of the current map.
+	baseConfig *Config
+
+	cvarsPolled time.Time
+	downloadURL string
+
+	// Messages of background tasks for the server console.
+	console consoleQueue
+
+	// precachedMu guards the precache list, it is written on the game thread and read by downloads.
+	precachedMu    sync.RWMutex
+	precachedFiles map[string]struct{}
+
+	gameBans *GameBans
+	autoBans *AutoBans
+	clients  *ClientTracker
 }
 
 func NewPlugin() *Plugin {
-	return &Plugin{}
+	return &Plugin{
+		gameBans: NewGameBans(),
+		autoBans: NewAutoBans(),
+		clients:  NewClientTracker(),
+	}
 }
 
 func (p *Plugin) SetConfig(cfg *Config) {
-	p.cfg = cfg
+	p.cfg.Store(cfg)
+}
+
+func (p *Plugin) Config() *Config {
+	return p.cfg.Load()
+}
+
+// FileHandler returns the file handler of the current handler chain, nil if the server is not running.
+func (p *Plugin) FileHandler() *fileHandler {
+	chain := p.handlers.current.Load()
+	if chain == nil {
+		return nil
+	}
+
+	return chain.files
 }
 
 func (p *Plugin) SetGameDir(gameDir string) {
@@ -38,45 +88,63 @@ func (p *Plugin) Init() error {
 	return nil
 }
 
+// AppendPrecached adds the file to the precache list.
+// The list is collected even if servePrecached is disabled, so the option can be enabled by reload.
+// Names are case-insensitive like on the client.
 func (p *Plugin) AppendPrecached(filePath string) {
-	if !p.cfg.ServePrecached {
+	filePath = foldName(NormalizePath(filePath))
+	if filePath == "." {
 		return
 	}
 
+	p.precachedMu.Lock()
+	defer p.precachedMu.Unlock()
+
 	if p.precachedFiles == nil {
-		precachedFiles := make(map[string]struct{}, 250)
-		p.precachedFiles = &precachedFiles
+		p.precachedFiles = make(map[string]struct{}, 250)
 	}
 
-	(*p.precachedFiles)[filePath] = struct{}{}
+	p.precachedFiles[filePath] = struct{}{}
 
-	dir := filepath.Dir(filePath)
+	for dir := path.Dir(filePath); dir != "."; dir = path.Dir(dir) {
+		p.precachedFiles[dir] = struct{}{}
+	}
+}
 
-	for {
-		if dir == "." || dir == "/" {
-			break
-		}
+// IsPrecached reports whether the file or a file in the directory is precached.
+func (p *Plugin) IsPrecached(filePath string) bool {
+	filePath = foldName(NormalizePath(filePath))
 
-		(*p.precachedFiles)[dir] = struct{}{}
-		dir = filepath.Dir(dir)
-	}
+	p.precachedMu.RLock()
+	defer p.precachedMu.RUnlock()
+
+	_, ok := p.precachedFiles[filePath]
+
+	return ok
 }
 
 func (p *Plugin) Reset() error {
-	if p.cfg.ServePrecached {
-		precachedFiles := make(map[string]struct{}, 250)
-		p.precachedFiles = &precachedFiles
-	}
+	p.precachedMu.Lock()
+	p.precachedFiles = make(map[string]struct{}, 250)
+	p.precachedMu.Unlock()
 
 	return nil
 }
 
 func (p *Plugin) Shutdown() error {
-	if p.server == nil {
+	p.serverMu.Lock()
+	server := p.server
+	p.server = nil
+	p.serverMu.Unlock()
+
+	if server == nil {
 		return nil
 	}
 
-	err := p.server.Shutdown(context.TODO())
+	// Watchers, GeoIP readers and game file roots are released even if the shutdown fails.
+	defer p.stopServer()
+
+	err := server.Shutdown(context.TODO())
 	if err != nil {
 		return errors.Wrap(err, "failed to shutdown server")
 	}
@@ -84,37 +152,211 @@ func (p *Plugin) Shutdown() error {
 	return nil
 }
 
-func (p *Plugin) RunServer(gameDir string) error {
-	var h http.Handler
+// disabledHandler responds while the plugin is disabled, clients fall back to downloading from the game server.
+func disabledHandler(w http.ResponseWriter, _ *http.Request) {
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
 
-	h = newFileHandler(gameDir, p)
+func (p *Plugin) cleanupClients(ctx context.Context, ttl time.Duration) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
 
-	for _, rateLimit := range p.cfg.RateLimits {
-		if rateLimit.Period() > 0 {
-			h = rateLimitMiddleware(h, rateLimit.Period(), rateLimit.Limit())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.clients.Cleanup(ttl)
 		}
 	}
+}
+
+func (p *Plugin) RunServer(gameDir string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.serverCtx = ctx
+	p.stopServer = cancel
+
+	p.gameBans.Load(gameDir)
+	go p.gameBans.Watch(ctx)
+
+	cfg := p.Config()
+
+	chain, err := p.buildHandlerChain(ctx, cfg)
+	if err != nil {
+		cancel()
 
-	if len(p.cfg.BlockListIP) > 0 {
-		h = ipBlockMiddleware(h, p.cfg.BlockListIP)
+		return err
 	}
 
-	http.HandleFunc("/", h.ServeHTTP)
+	p.stateMu.Lock()
+	p.configFile = statConfigFile(gameDir)
+	p.baseConfig = cfg
+	p.handlers.swap(chain)
+	p.stateMu.Unlock()
 
-	addr := fmt.Sprintf("%s:%d", p.cfg.Host, p.cfg.Port)
+	go p.watchConfig(ctx)
 
-	p.server = &http.Server{
-		Addr: addr,
+	if err := p.listen(cfg.BindAddress, cfg.Port, cfg.HTTP); err != nil {
+		cancel()
+
+		return err
 	}
 
-	slog.Info(fmt.Sprintf("FastDL HTTP Starting server on %s...", addr))
+	return nil
+}
+
+// listen starts serving on the address. The previous server is shut down,
+// its downloads in progress are finished.
+func (p *Plugin) listen(bindAddress string, port uint16, timeouts ConfigHTTP) error {
+	addr := listenAddress(bindAddress, port)
 
-	err := p.server.ListenAndServe()
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return errors.Wrap(err, "failed to start server")
 	}
 
-	slog.Info("FastDL HTTP Server stopped")
+	server := &http.Server{
+		Handler:           &p.handlers,
+		ReadHeaderTimeout: timeouts.ReadHeaderTimeout(),
+		ReadTimeout:       timeouts.ReadTimeout.Duration(),
+		WriteTimeout:      timeouts.WriteTimeout.Duration(),
+	}
+
+	p.serverMu.Lock()
+	previous := p.server
+	p.server = server
+	p.serverMu.Unlock()
+
+	slog.Info(fmt.Sprintf("FastDL HTTP Starting server on %s...", addr))
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("FastDL HTTP Server failed", "address", addr, "error", err)
+
+			return
+		}
+
+		slog.Info("FastDL HTTP Server stopped", "address", addr)
+	}()
+
+	if previous != nil {
+		go func() {
+			if err := previous.Shutdown(context.Background()); err != nil {
+				slog.Error("Failed to shutdown server", "error", err)
+			}
+		}()
+	}
 
 	return nil
 }
+
+// buildHandlerChain builds the file handler and middlewares from the config.
+// Background jobs of the chain run until the chain is retired or ctx is done.
+func (p *Plugin) buildHandlerChain(ctx context.Context, cfg *Config) (*handlerChain, error) {
+	var h http.Handler
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	if !cfg.Enabled {
+		return &handlerChain{
+			cfg:     cfg,
+			handler: http.HandlerFunc(disabledHandler),
+			cancel:  cancel,
+		}, nil
+	}
+
+	handler, err := newFileHandler(p.gameDir, p, cfg)
+	if err != nil {
+		cancel()
+
+		return nil, err
+	}
+
+	h = handler
+
+	go func() {
+		<-ctx.Done()
+
+		if err := handler.fs.Close(); err != nil {
+			slog.Error("Failed to close search path directories", "error", err)
+		}
+	}()
+
+	h = adminMiddleware(h, handler)
+
+	for _, rateLimit := range cfg.RateLimits {
+		if rateLimit.Period.Duration() > 0 {
+			h = rateLimitMiddleware(h, rateLimit.Period.Duration(), rateLimit.Limit)
+		}
+	}
+
+	if cfg.OnlyConnectedClients {
+		ttl := cfg.ConnectedClientsTTL.Duration()
+		if ttl <= 0 {
+			ttl = defaultConnectedClientsTTL
+		}
+
+		go p.cleanupClients(ctx, ttl)
+
+		h = connectedClientsMiddleware(h, p.clients, ttl)
+	}
+
+	if len(cfg.GeoIP.Databases) > 0 {
+		geoIP, err := NewGeoIP(p.gameDir, cfg.GeoIP)
+		if err != nil {
+			cancel()
+
+			return nil, errors.WithMessage(err, "failed to load geoip")
+		}
+
+		go func() {
+			<-ctx.Done()
+
+			if err := geoIP.Close(); err != nil {
+				slog.Error("Failed to close GeoIP", "error", err)
+			}
+		}()
+
+		h = geoIPMiddleware(h, geoIP)
+	}
+
+	var blockers []ipBlocker
+
+	if cfg.IntrusionDetection.Enabled {
+		detector := NewIntrusionDetector(cfg.IntrusionDetection, p.autoBans)
+		go detector.Watch(ctx)
+
+		h = intrusionDetectionMiddleware(h, detector)
+
+		blockers = append(blockers, p.autoBans)
+	}
+
+	blockList := NewBlockList(p.gameDir, cfg.BlockListIP)
+	if !blockList.Empty() {
+		go blockList.Watch(ctx)
+
+		blockers = append(blockers, blockList)
+	}
+
+	if cfg.ShareGameBans {
+		blockers = append(blockers, p.gameBans)
+	}
+
+	if len(blockers) > 0 {
+		h = ipBlockMiddleware(h, blockers...)
+	}
+
+	// Client groups go first, so every middleware can use the membership.
+	clientGroups := NewClientGroups(cfg.ClientGroups, cfg.PrivateGroups)
+	if !clientGroups.Empty() {
+		h = clientGroupsMiddleware(h, clientGroups)
+	}
+
+	return &handlerChain{
+		cfg:     cfg,
+		handler: h,
+		files:   handler,
+		cancel:  cancel,
+	}, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListenTimeouts(t *testing.T) {
+	tests := []struct {
+		name           string
+		timeouts       ConfigHTTP
+		wantReadHeader time.Duration
+		wantRead       time.Duration
+		wantWrite      time.Duration
+	}{
+		{name: "defaults", wantReadHeader: defaultReadHeaderTimeout},
+		{
+			name:           "configured",
+			timeouts:       ConfigHTTP{ReadTimeout: "5s", WriteTimeout: "10m"},
+			wantReadHeader: 5 * time.Second,
+			wantRead:       5 * time.Second,
+			wantWrite:      10 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPlugin()
+
+			if err := p.listen("127.0.0.1", 0, tt.timeouts); err != nil {
+				t.Fatal(err)
+			}
+
+			defer p.server.Close()
+
+			if p.server.ReadHeaderTimeout != tt.wantReadHeader {
+				t.Errorf("ReadHeaderTimeout = %s, want %s", p.server.ReadHeaderTimeout, tt.wantReadHeader)
+			}
+
+			if p.server.ReadTimeout != tt.wantRead {
+				t.Errorf("ReadTimeout = %s, want %s", p.server.ReadTimeout, tt.wantRead)
+			}
+
+			if p.server.WriteTimeout != tt.wantWrite {
+				t.Errorf("WriteTimeout = %s, want %s", p.server.WriteTimeout, tt.wantWrite)
+			}
+		})
+	}
+}
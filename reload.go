@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const configPollInterval = 5 * time.Second
+
+// handlerChain is the middleware chain built from one config.
+type handlerChain struct {
+	cfg     *Config
+	handler http.Handler
+	files   *fileHandler
+	cancel  context.CancelFunc
+
+	mu      sync.RWMutex
+	retired bool
+}
+
+// retire waits for in-flight requests and releases the chain resources.
+func (c *handlerChain) retire() {
+	c.mu.Lock()
+	c.retired = true
+	c.mu.Unlock()
+
+	c.cancel()
+}
+
+// reloadableHandler serves requests with the current chain.
+// Requests started before a reload are finished by the previous chain.
+type reloadableHandler struct {
+	current atomic.Pointer[handlerChain]
+}
+
+func (h *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for {
+		chain := h.current.Load()
+
+		chain.mu.RLock()
+
+		if !chain.retired {
+			defer chain.mu.RUnlock()
+
+			chain.handler.ServeHTTP(w, r)
+
+			return
+		}
+
+		// The chain was swapped after it was loaded, retry with the new one.
+		chain.mu.RUnlock()
+	}
+}
+
+// swap replaces the current chain and retires the previous one in the background.
+func (h *reloadableHandler) swap(chain *handlerChain) {
+	previous := h.current.Swap(chain)
+	if previous != nil {
+		go previous.retire()
+	}
+}
+
+// configFileState identifies a version of the config file.
+type configFileState struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func statConfigFile(gameDir string) configFileState {
+	file := findConfigFile(gameDir)
+	if file == "" {
+		return configFileState{}
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return configFileState{path: file}
+	}
+
+	return configFileState{
+		path:    file,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+}
+
+// watchConfig polls the config file and reloads the config when it changes until ctx is done.
+func (p *Plugin) watchConfig(ctx context.Context) {
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.stateMu.Lock()
+			configFile := p.configFile
+			p.stateMu.Unlock()
+
+			changed := statConfigFile(p.gameDir) != configFile
+
+			if !changed {
+				continue
+			}
+
+			slog.Info("Config file changed, reloading")
+
+			if err := p.Reload(); err != nil {
+				slog.Error("Failed to reload config", "error", err)
+			}
+		}
+	}
+}
+
+// Reload reads the config file, applies cvars and the sections of the current map, and swaps the handler chain.
+// If the config is invalid, the current config is kept.
+// Changes of the listen address and the download URL are reported and take effect after restart.
+// Building the chain walks the game files, so Reload must not be called on the game thread.
+func (p *Plugin) Reload() error {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	p.stateMu.Lock()
+	p.configFile = statConfigFile(p.gameDir)
+	cvars := p.cvars
+	p.stateMu.Unlock()
+
+	cfg, err := loadConfig(p.gameDir)
+	if err != nil {
+		return errors.WithMessage(err, "current config is kept")
+	}
+
+	for _, err := range applyCVars(cfg, cvars) {
+		slog.Error("Invalid cvar value", "error", err)
+	}
+
+	current := p.Config()
+
+	for _, option := range restartRequiredOptions(current, cfg) {
+		slog.Warn("Config option change requires restart", "option", option)
+	}
+
+	cfg.Host = current.Host
+	cfg.BindAddress = current.BindAddress
+	cfg.PublicHost = current.PublicHost
+	cfg.Port = current.Port
+	cfg.PortRange = current.PortRange
+	cfg.CustomDownloadURL = current.CustomDownloadURL
+	cfg.HTTP = current.HTTP
+
+	if p.handlers.current.Load() == nil {
+		p.stateMu.Lock()
+		defer p.stateMu.Unlock()
+
+		_, err := p.applyMap(cfg, nil)
+
+		return err
+	}
+
+	chain, err := p.buildHandlerChain(p.serverCtx, cfg)
+	if err != nil {
+		return errors.WithMessage(err, "current config is kept")
+	}
+
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+
+	mapped, err := p.applyMap(cfg, chain.files)
+	if err != nil {
+		chain.cancel()
+
+		return errors.WithMessage(err, "current config is kept")
+	}
+
+	p.handlers.swap(chain)
+
+	slog.Info("Config reloaded", "config", mapped)
+
+	return nil
+}
+
+// applyMap applies the sections of the current map to the base config,
+// updates the rules of the file handler and sets the config. stateMu must be held.
+func (p *Plugin) applyMap(base *Config, files *fileHandler) (*Config, error) {
+	cfg, err := base.WithMap(p.mapName)
+	if err != nil {
+		return nil, err
+	}
+
+	if files != nil {
+		rules, err := NewAccessRules(cfg)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to build access rules")
+		}
+
+		files.setAccess(cfg, rules)
+	}
+
+	p.baseConfig = base
+	p.SetConfig(cfg)
+
+	return cfg, nil
+}
+
+// setPort changes the port of the running config after the listener was restarted.
+func (p *Plugin) setPort(port uint16) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+
+	if p.baseConfig != nil {
+		base := *p.baseConfig
+		base.Port = port
+		p.baseConfig = &base
+	}
+
+	cfg := *p.Config()
+	cfg.Port = port
+	p.SetConfig(&cfg)
+}
+
+// restartRequiredOptions returns changed options the running server can not apply.
+// Empty addresses and port are resolved on startup, so they are not compared.
+func restartRequiredOptions(current, updated *Config) []string {
+	var options []string
+
+	if updated.Host != current.Host {
+		options = append(options, "host")
+	}
+
+	if updated.BindAddress != "" && updated.BindAddress != current.BindAddress {
+		options = append(options, "bindAddress")
+	}
+
+	if updated.PublicHost != "" && updated.PublicHost != current.PublicHost {
+		options = append(options, "publicHost")
+	}
+
+	if updated.Port != 0 && updated.Port != current.Port {
+		options = append(options, "port")
+	}
+
+	if updated.Port == 0 && updated.PortRange != current.PortRange {
+		options = append(options, "portRange")
+	}
+
+	if updated.CustomDownloadURL != current.CustomDownloadURL {
+		options = append(options, "customDownloadURL")
+	}
+
+	if updated.HTTP.ReadTimeout != current.HTTP.ReadTimeout {
+		options = append(options, "http.readTimeout")
+	}
+
+	if updated.HTTP.WriteTimeout != current.HTTP.WriteTimeout {
+		options = append(options, "http.writeTimeout")
+	}
+
+	return options
+}
+
+// ActivateMap applies the maps sections for the new map.
+// Only the rules of the file handler are replaced, the rest of the handler chain is kept,
+// so it is cheap enough for the game thread.
+func (p *Plugin) ActivateMap(mapName string) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+
+	if p.mapName == mapName {
+		return
+	}
+
+	p.mapName = mapName
+
+	if p.baseConfig == nil || len(p.baseConfig.Maps) == 0 {
+		return
+	}
+
+	slog.Info("Applying map config", "map", mapName)
+
+	if _, err := p.applyMap(p.baseConfig, p.FileHandler()); err != nil {
+		slog.Error("Failed to apply map config", "map", mapName, "error", err)
+	}
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	rulesMatchFirst = "first"
+	rulesMatchLast  = "last"
+)
+
+// AccessRules is an ordered list of allow and deny rules evaluated
+// identically for files and directories.
+//
+// Rules use gitignore-style globs:
+//   - "!" prefix turns an allow rule into a deny rule
+//   - a pattern without a slash matches the name at any depth
+//   - a leading or middle slash anchors the pattern to the game directory
+//   - a trailing slash matches directories only
+//   - "*" and "?" do not match "/", "**" matches any number of directories
+//   - "{a,b}" matches any of the alternatives
+//
+// A path is denied if any of its parent directories is denied by a rule.
+//
+// The baseline deny list is applied before the rules regardless of the config,
+// unless the path matches one of the baseline overrides.
+type AccessRules struct {
+	baseline  []*accessRule
+	overrides []*accessRule
+
+	rules        []*accessRule
+	lastMatch    bool
+	defaultAllow bool
+}
+
+// baselineDenyPatterns protect GoldSrc server-side files.
+var baselineDenyPatterns = []string{
+	// Hidden files and directories at any depth.
+	"!.*",
+	// Server-side plugins, logs and binaries.
+	"!addons",
+	"!/logs",
+	"!/dlls",
+	"!/scripts/plugins",
+	"!*.{so,dll,dylib,exe,sh,bat,py,php}",
+	"!*.{amx,amxx,sma,inc}",
+	// Configs, databases and backups, including copies like server.cfg.bak.
+	"!*.{cfg,ini,json,yml,yaml,env,log}",
+	"!*.{sq3,sqlite,db,pem,key}",
+	"!*.cfg.*",
+	"!*.{bak,old,orig,swp}",
+	"!*~",
+}
+
+const decisionHeader = "X-FastDL-Decision"
+
+// accessDecision explains why a path is allowed or denied.
+type accessDecision struct {
+	Allowed bool
+	Reason  string
+}
+
+func (d accessDecision) String() string {
+	if d.Allowed {
+		return "allow: " + d.Reason
+	}
+
+	return "deny: " + d.Reason
+}
+
+func rulesDecision(allowed bool, rule *accessRule) accessDecision {
+	if rule != nil {
+		return accessDecision{Allowed: allowed, Reason: rule.source}
+	}
+
+	if allowed {
+		return accessDecision{Allowed: true, Reason: "no rule matched, there are no allow rules"}
+	}
+
+	return accessDecision{Reason: "no rule matched"}
+}
+
+type accessRule struct {
+	source  string
+	allow   bool
+	dirOnly bool
+
+	// baseName rules match both the full path and the base name, used for regexps.
+	baseName bool
+	re       *regexp.Regexp
+}
+
+func (r *accessRule) String() string {
+	return r.source
+}
+
+func (r *accessRule) matches(filePath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	if r.re.MatchString(filePath) {
+		return true
+	}
+
+	return r.baseName && r.re.MatchString(path.Base(filePath))
+}
+
+// NewAccessRules builds rules from the config.
+// The legacy keys (forbiddenRegexp, forbiddenPaths, forbiddenExtensions,
+// allowedPaths, allowedExtensions) are translated into rules evaluated after
+// the rules from the "rules" key in the first-match mode and before them in the last-match mode.
+func NewAccessRules(cfg *Config) (*AccessRules, error) {
+	ar := &AccessRules{
+		baseline:  make([]*accessRule, 0, len(baselineDenyPatterns)),
+		overrides: make([]*accessRule, 0, len(cfg.BaselineOverrides)),
+	}
+
+	for _, pattern := range baselineDenyPatterns {
+		r, err := newGlobRule(fmt.Sprintf("baseline %q", pattern), pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		ar.baseline = append(ar.baseline, r)
+	}
+
+	for i, pattern := range cfg.BaselineOverrides {
+		r, err := newGlobRule(fmt.Sprintf("baselineOverrides[%d] %q", i, pattern), pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		if !r.allow {
+			return nil, errors.Errorf("%s: baseline overrides can not deny", r.source)
+		}
+
+		ar.overrides = append(ar.overrides, r)
+	}
+
+	switch strings.ToLower(cfg.RulesMatch) {
+	case "", rulesMatchFirst:
+	case rulesMatchLast:
+		ar.lastMatch = true
+	default:
+		return nil, errors.Errorf("invalid rulesMatch %q, expected %q or %q", cfg.RulesMatch, rulesMatchFirst, rulesMatchLast)
+	}
+
+	userRules := make([]*accessRule, 0, len(cfg.Rules))
+
+	for i, rule := range cfg.Rules {
+		r, err := newGlobRule(fmt.Sprintf("rules[%d] %q", i, rule), rule)
+		if err != nil {
+			return nil, err
+		}
+
+		userRules = append(userRules, r)
+	}
+
+	legacyRules, err := legacyAccessRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if ar.lastMatch {
+		ar.rules = append(legacyRules, userRules...)
+	} else {
+		ar.rules = append(userRules, legacyRules...)
+	}
+
+	ar.defaultAllow = !slices.ContainsFunc(ar.rules, func(r *accessRule) bool {
+		return r.allow
+	})
+
+	return ar, nil
+}
+
+// Evaluate returns whether the path is allowed and the rule that decided it.
+// The rule is nil when the default applies.
+func (ar *AccessRules) Evaluate(filePath string, isDir bool) (bool, *accessRule) {
+	filePath = strings.Trim(filePath, "/")
+
+	if filePath == "" {
+		return true, nil
+	}
+
+	if rule := ar.baselineMatch(filePath, isDir); rule != nil {
+		return false, rule
+	}
+
+	for dir := path.Dir(filePath); dir != "."; dir = path.Dir(dir) {
+		if rule := ar.match(dir, true); rule != nil && !rule.allow {
+			return false, rule
+		}
+	}
+
+	rule := ar.match(filePath, isDir)
+	if rule == nil {
+		return ar.defaultAllow, nil
+	}
+
+	return rule.allow, rule
+}
+
+// baselineMatch returns the baseline rule denying the path or any of its parents.
+func (ar *AccessRules) baselineMatch(filePath string, isDir bool) *accessRule {
+	for _, override := range ar.overrides {
+		if override.matches(filePath, isDir) {
+			return nil
+		}
+	}
+
+	for _, rule := range ar.baseline {
+		if rule.matches(filePath, isDir) {
+			return rule
+		}
+	}
+
+	for dir := path.Dir(filePath); dir != "."; dir = path.Dir(dir) {
+		for _, rule := range ar.baseline {
+			if rule.matches(dir, true) {
+				return rule
+			}
+		}
+	}
+
+	return nil
+}
+
+func (ar *AccessRules) match(filePath string, isDir bool) *accessRule {
+	if ar.lastMatch {
+		for i := len(ar.rules) - 1; i >= 0; i-- {
+			if ar.rules[i].matches(filePath, isDir) {
+				return ar.rules[i]
+			}
+		}
+
+		return nil
+	}
+
+	for _, rule := range ar.rules {
+		if rule.matches(filePath, isDir) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+func legacyAccessRules(cfg *Config) ([]*accessRule, error) {
+	var rules []*accessRule
+
+	add := func(source, pattern string) error {
+		r, err := newGlobRule(source, pattern)
+		if err != nil {
+			return err
+		}
+
+		rules = append(rules, r)
+
+		return nil
+	}
+
+	for _, expr := range cfg.ForbiddenRegexp {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid forbiddenRegexp %q", expr)
+		}
+
+		rules = append(rules, &accessRule{
+			source:   fmt.Sprintf("forbiddenRegexp %q", expr),
+			baseName: true,
+			re:       re,
+		})
+	}
+
+	for _, p := range cfg.ForbiddenPaths {
+		if err := add(fmt.Sprintf("forbiddenPaths %q", p), "!/"+escapeGlob(strings.Trim(p, "/"))); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cfg.ForbiddenExtensions) > 0 {
+		pattern := "!*." + globAlternatives(cfg.ForbiddenExtensions)
+		if err := add("forbiddenExtensions", pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	extensions := globAlternatives(cfg.AllowedExtensions)
+	paths := make([]string, 0, len(cfg.AllowedPaths))
+
+	for _, p := range cfg.AllowedPaths {
+		paths = append(paths, strings.Trim(p, "/"))
+	}
+
+	pathsAlternatives := globAlternatives(paths)
+
+	switch {
+	case len(paths) > 0 && len(cfg.AllowedExtensions) > 0:
+		if err := add("allowedPaths", "/"+pathsAlternatives+"/"); err != nil {
+			return nil, err
+		}
+
+		if err := add("allowedPaths", "/"+pathsAlternatives+"/**/"); err != nil {
+			return nil, err
+		}
+
+		if err := add("allowedPaths and allowedExtensions", "/"+pathsAlternatives+"/**/*."+extensions); err != nil {
+			return nil, err
+		}
+
+		// WAD files are stored in the game directory root.
+		if slices.Contains(cfg.AllowedExtensions, "wad") {
+			if err := add("allowedExtensions \"wad\"", "/*.wad"); err != nil {
+				return nil, err
+			}
+		}
+	case len(paths) > 0:
+		if err := add("allowedPaths", "/"+pathsAlternatives); err != nil {
+			return nil, err
+		}
+
+		if err := add("allowedPaths", "/"+pathsAlternatives+"/**"); err != nil {
+			return nil, err
+		}
+	case len(cfg.AllowedExtensions) > 0:
+		if err := add("allowedExtensions", "**/"); err != nil {
+			return nil, err
+		}
+
+		if err := add("allowedExtensions", "*."+extensions); err != nil {
+			return nil, err
+		}
+	}
+
+	return rules, nil
+}
+
+func newGlobRule(source, pattern string) (*accessRule, error) {
+	rule := &accessRule{
+		source: source,
+		allow:  true,
+	}
+
+	if p, ok := strings.CutPrefix(pattern, "!"); ok {
+		rule.allow = false
+		pattern = p
+	}
+
+	if p, ok := strings.CutSuffix(pattern, "/"); ok {
+		rule.dirOnly = true
+		pattern = p
+	}
+
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	if pattern == "" {
+		return nil, errors.Errorf("%s: empty pattern", source)
+	}
+
+	expr, err := globToRegexp(pattern)
+	if err != nil {
+		return nil, errors.WithMessage(err, source)
+	}
+
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+
+	// The game file system is case-insensitive.
+	re, err := regexp.Compile("(?i)^" + expr + "$")
+	if err != nil {
+		return nil, errors.WithMessage(err, source)
+	}
+
+	rule.re = re
+
+	return rule, nil
+}
+
+// globSpecialChars are the characters globToRegexp translates, other characters are literal.
+const globSpecialChars = `*?[{\`
+
+func globToRegexp(pattern string) (string, error) {
+	var sb strings.Builder
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+
+				continue
+			}
+
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return "", errors.Errorf("unterminated [ in pattern %q", pattern)
+			}
+
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '{':
+			end := matchingBrace(pattern, i)
+			if end < 0 {
+				return "", errors.Errorf("unterminated { in pattern %q", pattern)
+			}
+
+			alternatives := splitAlternatives(pattern[i+1 : end])
+			converted := make([]string, 0, len(alternatives))
+
+			for _, alternative := range alternatives {
+				expr, err := globToRegexp(alternative)
+				if err != nil {
+					return "", err
+				}
+
+				converted = append(converted, expr)
+			}
+
+			sb.WriteString("(?:" + strings.Join(converted, "|") + ")")
+			i = end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+
+			_, size := utf8.DecodeRuneInString(pattern[i:])
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+size]))
+			i += size - 1
+		default:
+			// Copy the literal run as is, special characters are ASCII, so the run keeps multibyte characters intact.
+			end := i + 1
+			for end < len(pattern) && !strings.ContainsRune(globSpecialChars, rune(pattern[end])) {
+				end++
+			}
+
+			sb.WriteString(regexp.QuoteMeta(pattern[i:end]))
+			i = end - 1
+		}
+	}
+
+	return sb.String(), nil
+}
+
+func matchingBrace(pattern string, start int) int {
+	depth := 0
+
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func splitAlternatives(s string) []string {
+	var parts []string
+
+	depth := 0
+	last := 0
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[last:])
+}
+
+func globAlternatives(items []string) string {
+	escaped := make([]string, 0, len(items))
+
+	for _, item := range items {
+		escaped = append(escaped, escapeGlob(item))
+	}
+
+	if len(escaped) == 1 {
+		return escaped[0]
+	}
+
+	return "{" + strings.Join(escaped, ",") + "}"
+}
+
+func escapeGlob(s string) string {
+	var sb strings.Builder
+
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]{},\!`, c) {
+			sb.WriteByte('\\')
+		}
+
+		sb.WriteRune(c)
+	}
+
+	return sb.String()
+}
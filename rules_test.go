@@ -0,0 +1,219 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{pattern: "maps", want: `maps`},
+		{pattern: "maps/de_dust2.bsp", want: `maps/de_dust2\.bsp`},
+		{pattern: "*.wav", want: `[^/]*\.wav`},
+		{pattern: "sound/?.wav", want: `sound/[^/]\.wav`},
+		{pattern: "sound/**", want: `sound/.*`},
+		{pattern: "**/*.bsp", want: `(?:.*/)?[^/]*\.bsp`},
+		{pattern: "sound/**/a.wav", want: `sound/(?:.*/)?a\.wav`},
+		{pattern: "*.{wav,mp3}", want: `[^/]*\.(?:wav|mp3)`},
+		{pattern: "{a,{b,c}}", want: `(?:a|(?:b|c))`},
+		{pattern: "[abc].wav", want: `[abc]\.wav`},
+		{pattern: "[!abc].wav", want: `[^abc]\.wav`},
+		{pattern: `a\*b`, want: `a\*b`},
+		{pattern: `a\{b`, want: `a\{b`},
+		{pattern: "a+b(c)", want: `a\+b\(c\)`},
+		{pattern: "a}b]", want: `a\}b\]`},
+		{pattern: "sound/секрет/**", want: `sound/секрет/.*`},
+		{pattern: "sound/музыка*.wav", want: `sound/музыка[^/]*\.wav`},
+		{pattern: "sound/?ö.wav", want: `sound/[^/]ö\.wav`},
+		{pattern: `sound/\секрет`, want: `sound/секрет`},
+		{pattern: "{звук,музыка}/*", want: `(?:звук|музыка)/[^/]*`},
+		{pattern: "[аб].wav", want: `[аб]\.wav`},
+		{pattern: "日本/*.wav", want: `日本/[^/]*\.wav`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := globToRegexp(tt.pattern)
+			if err != nil {
+				t.Fatalf("globToRegexp(%q) error: %v", tt.pattern, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("globToRegexp(%q) = %q, want %q", tt.pattern, got, tt.want)
+			}
+
+			if _, err := regexp.Compile(got); err != nil {
+				t.Errorf("globToRegexp(%q) = %q does not compile: %v", tt.pattern, got, err)
+			}
+		})
+	}
+}
+
+func TestGlobToRegexpErrors(t *testing.T) {
+	for _, pattern := range []string{"[abc", "{a,b", "sound/{a,{b,c}"} {
+		if got, err := globToRegexp(pattern); err == nil {
+			t.Errorf("globToRegexp(%q) = %q, want error", pattern, got)
+		}
+	}
+}
+
+func TestAccessRulesEvaluate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{
+			name: "allowed path and extension",
+			cfg:  Config{AllowedPaths: []string{"sound"}, AllowedExtensions: []string{"wav"}},
+			path: "sound/a.wav",
+			want: true,
+		},
+		{
+			name: "extension not allowed",
+			cfg:  Config{AllowedPaths: []string{"sound"}, AllowedExtensions: []string{"wav"}},
+			path: "sound/a.mp3",
+			want: false,
+		},
+		{
+			name: "path not allowed",
+			cfg:  Config{AllowedPaths: []string{"sound"}, AllowedExtensions: []string{"wav"}},
+			path: "models/a.wav",
+			want: false,
+		},
+		{
+			name: "rules are case-insensitive",
+			cfg:  Config{AllowedPaths: []string{"sound"}, AllowedExtensions: []string{"wav"}},
+			path: "SOUND/A.WAV",
+			want: true,
+		},
+		{
+			name: "deny rule before legacy allow",
+			cfg:  Config{Rules: []string{"!sound/private/**"}, AllowedPaths: []string{"sound"}, AllowedExtensions: []string{"wav"}},
+			path: "sound/private/a.wav",
+			want: false,
+		},
+		{
+			name: "non-ascii deny rule before legacy allow",
+			cfg:  Config{Rules: []string{"!sound/секрет/**"}, AllowedPaths: []string{"sound"}, AllowedExtensions: []string{"wav"}},
+			path: "sound/секрет/a.wav",
+			want: false,
+		},
+		{
+			name: "non-ascii deny rule is case-insensitive",
+			cfg:  Config{Rules: []string{"!sound/секрет/**"}, AllowedPaths: []string{"sound"}, AllowedExtensions: []string{"wav"}},
+			path: "sound/СЕКРЕТ/a.wav",
+			want: false,
+		},
+		{
+			name: "non-ascii deny rule keeps other paths",
+			cfg:  Config{Rules: []string{"!sound/секрет/**"}, AllowedPaths: []string{"sound"}, AllowedExtensions: []string{"wav"}},
+			path: "sound/музыка/a.wav",
+			want: true,
+		},
+		{
+			name: "non-ascii allowed path",
+			cfg:  Config{AllowedPaths: []string{"звуки"}, AllowedExtensions: []string{"wav"}},
+			path: "звуки/a.wav",
+			want: true,
+		},
+		{
+			name: "non-ascii allowed path does not match other names",
+			cfg:  Config{AllowedPaths: []string{"звуки"}, AllowedExtensions: []string{"wav"}},
+			path: "Ð·Ð²ÑƒÐºÐ¸/a.wav",
+			want: false,
+		},
+		{
+			name: "non-ascii forbidden path",
+			cfg:  Config{ForbiddenPaths: []string{"sound/секрет"}},
+			path: "sound/секрет/a.wav",
+			want: false,
+		},
+		{
+			name: "denied parent directory",
+			cfg:  Config{Rules: []string{"!private/", "*"}},
+			path: "private/maps/a.bsp",
+			want: false,
+		},
+		{
+			name: "baseline denies configs",
+			cfg:  Config{Rules: []string{"*"}},
+			path: "server.cfg",
+			want: false,
+		},
+		{
+			name: "baseline denies addons",
+			cfg:  Config{Rules: []string{"*"}},
+			path: "addons/metamod/plugins.ini",
+			want: false,
+		},
+		{
+			name: "baseline denies sven co-op plugins",
+			cfg:  Config{AllowedPaths: []string{"scripts"}, AllowedExtensions: []string{"as"}},
+			path: "scripts/plugins/admin.as",
+			want: false,
+		},
+		{
+			name: "nested allowed path",
+			cfg:  Config{AllowedPaths: []string{"scripts/maps"}, AllowedExtensions: []string{"as"}},
+			path: "scripts/maps/ctf.as",
+			want: true,
+		},
+		{
+			name: "nested allowed path does not allow its parent",
+			cfg:  Config{AllowedPaths: []string{"scripts/maps"}, AllowedExtensions: []string{"as"}},
+			path: "scripts/secret.as",
+			want: false,
+		},
+		{
+			name: "baseline override",
+			cfg:  Config{Rules: []string{"*"}, BaselineOverrides: []string{"/maps/*.cfg"}},
+			path: "maps/de_dust2.cfg",
+			want: true,
+		},
+		{
+			name: "first match",
+			cfg:  Config{Rules: []string{"sound/a.wav", "!sound/*"}},
+			path: "sound/a.wav",
+			want: true,
+		},
+		{
+			name: "last match",
+			cfg:  Config{Rules: []string{"sound/a.wav", "!sound/*"}, RulesMatch: rulesMatchLast},
+			path: "sound/a.wav",
+			want: false,
+		},
+		{
+			name:  "directory only rule",
+			cfg:   Config{Rules: []string{"!cache/", "*"}},
+			path:  "cache",
+			isDir: false,
+			want:  true,
+		},
+		{
+			name: "no allow rules allow by default",
+			cfg:  Config{Rules: []string{"!*.bak"}},
+			path: "maps/a.bsp",
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := NewAccessRules(&tt.cfg)
+			if err != nil {
+				t.Fatalf("NewAccessRules error: %v", err)
+			}
+
+			got, rule := rules.Evaluate(tt.path, tt.isDir)
+			if got != tt.want {
+				t.Errorf("Evaluate(%q) = %t by %v, want %t", tt.path, got, rule, tt.want)
+			}
+		})
+	}
+}
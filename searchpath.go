@@ -0,0 +1,204 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Suffixes of the mod directories the engine searches besides the mod directory itself.
+const (
+	searchPathAddon     = "_addon"
+	searchPathHD        = "_hd"
+	searchPathDownloads = "_downloads"
+)
+
+// SearchPath returns the directories the engine loads content from, in the engine order:
+// <mod>_addon, <mod>_hd, <mod>, <mod>_downloads, fallback_dir from liblist.gam and valve.
+// Directories are relative to the parent of the game directory.
+func SearchPath(gameDir string) []string {
+	mod := filepath.Base(gameDir)
+
+	dirs := []string{
+		mod + searchPathAddon,
+		mod + searchPathHD,
+		mod,
+		mod + searchPathDownloads,
+	}
+
+	liblist, err := readLiblist(gameDir)
+	if err == nil && liblist["fallback_dir"] != "" {
+		dirs = append(dirs, liblist["fallback_dir"])
+	}
+
+	dirs = append(dirs, "valve")
+
+	unique := dirs[:0]
+	for _, dir := range dirs {
+		if !slices.ContainsFunc(unique, func(d string) bool { return strings.EqualFold(d, dir) }) {
+			unique = append(unique, dir)
+		}
+	}
+
+	return unique
+}
+
+// overlayFS is a read-only view of the search path directories.
+// A name resolves to the first directory containing it, directory listings are merged.
+// Access rules are applied to the names, so they do not depend on the directory a file comes from.
+type overlayFS struct {
+	layers []overlayLayer
+}
+
+type overlayLayer struct {
+	dir string
+	fs  *gameFS
+}
+
+var (
+	_ fs.StatFS     = (*overlayFS)(nil)
+	_ fs.ReadDirFS  = (*overlayFS)(nil)
+	_ fs.ReadFileFS = (*overlayFS)(nil)
+)
+
+// newOverlayFS opens the search path directories of the game directory.
+// An empty searchPath means the engine search path. Missing directories are skipped,
+// except the game directory itself.
+func newOverlayFS(gameDir string, searchPath []string, symlinks string) (*overlayFS, error) {
+	if len(searchPath) == 0 {
+		searchPath = SearchPath(gameDir)
+	}
+
+	parentDir := filepath.Dir(gameDir)
+	mod := filepath.Base(gameDir)
+
+	overlay := &overlayFS{}
+
+	for _, dir := range searchPath {
+		if _, err := os.Stat(filepath.Join(parentDir, dir)); errors.Is(err, fs.ErrNotExist) && dir != mod {
+			slog.Debug("Search path directory not found", "dir", dir)
+
+			continue
+		}
+
+		layer, err := newGameFS(filepath.Join(parentDir, dir), symlinks)
+		if err != nil {
+			_ = overlay.Close()
+
+			return nil, errors.WithMessagef(err, "failed to open search path directory %q", dir)
+		}
+
+		overlay.layers = append(overlay.layers, overlayLayer{dir: dir, fs: layer})
+	}
+
+	return overlay, nil
+}
+
+func (o *overlayFS) Close() error {
+	var firstErr error
+
+	for _, layer := range o.layers {
+		if err := layer.fs.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Locate returns the search path directory the name resolves to.
+func (o *overlayFS) Locate(name string) (string, error) {
+	layer, err := o.layer("stat", name)
+	if err != nil {
+		return "", err
+	}
+
+	return layer.dir, nil
+}
+
+func (o *overlayFS) layer(op, name string) (overlayLayer, error) {
+	if !validGameFSName(name) {
+		return overlayLayer{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	for _, layer := range o.layers {
+		if _, err := layer.fs.Stat(name); err == nil {
+			return layer, nil
+		}
+	}
+
+	return overlayLayer{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	layer, err := o.layer("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return layer.fs.Open(name)
+}
+
+func (o *overlayFS) Stat(name string) (fs.FileInfo, error) {
+	layer, err := o.layer("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return layer.fs.Stat(name)
+}
+
+func (o *overlayFS) ReadFile(name string) ([]byte, error) {
+	layer, err := o.layer("read", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return layer.fs.ReadFile(name)
+}
+
+// ReadDir merges the directory entries of all layers, an entry of an earlier layer hides later ones.
+func (o *overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !validGameFSName(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	var (
+		merged []fs.DirEntry
+		found  bool
+		seen   = make(map[string]struct{})
+	)
+
+	for _, layer := range o.layers {
+		entries, err := layer.fs.ReadDir(name)
+		if err != nil {
+			continue
+		}
+
+		found = true
+
+		for _, entry := range entries {
+			if _, ok := seen[entry.Name()]; ok {
+				continue
+			}
+
+			seen[entry.Name()] = struct{}{}
+			merged = append(merged, entry)
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	slices.SortFunc(merged, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	return merged, nil
+}
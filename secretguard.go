@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultSecretPatterns are the default value of the secretGuard.patterns option.
+var defaultSecretPatterns = []string{
+	`(?i)\brcon_password\b`,
+	`(?i)\bsv_password\b`,
+	`(?i)\b(?:amx_)?sql_pass(?:word)?\b`,
+	`(?i)\b(?:mysql|mariadb|postgres(?:ql)?|mongodb(?:\+srv)?|redis|sqlserver)://[^\s/:@]+:[^\s/@]+@`,
+	`(?i)(?:^|;)\s*(?:password|pwd)\s*=\s*[^;\s]+`,
+	`(?i)\b(?:api[_-]?key|secret[_-]?key|access[_-]?token|auth[_-]?token)\b\s*[:=]`,
+	`-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----`,
+	`\bAKIA[0-9A-Z]{16}\b`,
+}
+
+// SecretGuard refuses to serve text files containing credentials.
+type SecretGuard struct {
+	patterns []*regexp.Regexp
+}
+
+func NewSecretGuard(cfg ConfigSecretGuard) (*SecretGuard, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	guard := &SecretGuard{
+		patterns: make([]*regexp.Regexp, 0, len(cfg.Patterns)),
+	}
+
+	for _, expr := range cfg.Patterns {
+		re, err := regexp.Compile("(?m)" + expr)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid secret pattern %q", expr)
+		}
+
+		guard.patterns = append(guard.patterns, re)
+	}
+
+	return guard, nil
+}
+
+// Inspect returns the first pattern found in the contents of a text file.
+// Files of other types are not inspected.
+func (g *SecretGuard) Inspect(filePath string, contents []byte) (string, bool) {
+	if g == nil {
+		return "", false
+	}
+
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(filePath)), ".")
+	if _, ok := textExtensions[ext]; !ok {
+		return "", false
+	}
+
+	for _, re := range g.patterns {
+		if re.Match(contents) {
+			return strings.TrimPrefix(re.String(), "(?m)"), true
+		}
+	}
+
+	return "", false
+}
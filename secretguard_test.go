@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestSecretGuardPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     string
+		contents string
+		want     bool
+	}{
+		{name: "default patterns", yaml: "", contents: "rcon_password secret", want: true},
+		{name: "append keeps default patterns", yaml: "secretGuard:\n  patterns:\n    append: [MY_TOKEN]\n", contents: "rcon_password secret", want: true},
+		{name: "append adds a pattern", yaml: "secretGuard:\n  patterns:\n    append: [MY_TOKEN]\n", contents: "MY_TOKEN=1", want: true},
+		{name: "list replaces default patterns", yaml: "secretGuard:\n  patterns: [MY_TOKEN]\n", contents: "rcon_password secret", want: false},
+		{name: "disabled", yaml: "secretGuard:\n  enabled: false\n", contents: "rcon_password secret", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseConfig(DefaultConfig(), []byte(tt.yaml))
+			if err != nil {
+				t.Fatalf("ParseConfig error: %v", err)
+			}
+
+			guard, err := NewSecretGuard(cfg.SecretGuard)
+			if err != nil {
+				t.Fatalf("NewSecretGuard error: %v", err)
+			}
+
+			if _, got := guard.Inspect("maps/de_dust2.txt", []byte(tt.contents)); got != tt.want {
+				t.Errorf("Inspect(%q) = %t, want %t", tt.contents, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,76 +1,99 @@
 package main
 
 import (
+	"bytes"
 	"github.com/et-nik/fastdl-mm/template"
+	"github.com/pkg/errors"
 	"log/slog"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"sync/atomic"
 )
 
 type fileHandler struct {
 	baseDir string
 	plugin  *Plugin
-	config  *Config
 
+	// The config and the rules are replaced on map change without rebuilding the handler.
+	access atomic.Pointer[fileAccess]
+
+	fs        *overlayFS
+	caseIndex *caseIndex
+	encodings filenameEncodings
 	fileCache *MRUCache
 
-	allowedExtensions   map[string]struct{}
-	forbiddenExtensions map[string]struct{}
-	allowedPaths        map[string]struct{}
-	forbiddenPaths      map[string]struct{}
-	forbiddenRegexps    []*regexp.Regexp
+	secretGuard *SecretGuard
+}
+
+// fileAccess is the config with the overrides of the current map and the access rules built from it.
+type fileAccess struct {
+	config *Config
+	rules  *AccessRules
 }
 
-func newFileHandler(baseDir string, plugin *Plugin) *fileHandler {
-	allowedExtensions := make(map[string]struct{}, len(plugin.cfg.AllowedExtensions))
-	for _, ext := range plugin.cfg.AllowedExtensions {
-		allowedExtensions[ext] = struct{}{}
+func newFileHandler(baseDir string, plugin *Plugin, cfg *Config) (*fileHandler, error) {
+	rules, err := NewAccessRules(cfg)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to build access rules")
 	}
 
-	forbiddenExtensions := make(map[string]struct{}, len(plugin.cfg.ForbiddenExtensions))
-	for _, ext := range plugin.cfg.ForbiddenExtensions {
-		forbiddenExtensions[ext] = struct{}{}
+	secretGuard, err := NewSecretGuard(cfg.SecretGuard)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to build secret guard")
 	}
 
-	allowedPaths := make(map[string]struct{}, len(plugin.cfg.AllowedPaths))
-	for _, path := range plugin.cfg.AllowedPaths {
-		allowedPaths[path] = struct{}{}
+	encodings, err := newFilenameEncodings(cfg.FilenameEncodings)
+	if err != nil {
+		return nil, err
 	}
 
-	forbiddenPaths := make(map[string]struct{}, len(plugin.cfg.ForbiddenPaths))
-	for _, path := range plugin.cfg.ForbiddenPaths {
-		forbiddenPaths[path] = struct{}{}
+	overlay, err := newOverlayFS(baseDir, cfg.SearchPaths, cfg.Symlinks)
+	if err != nil {
+		return nil, err
 	}
 
-	forbiddenRegexps := make([]*regexp.Regexp, 0, len(plugin.cfg.ForbiddenRegexp))
-	for _, regxp := range plugin.cfg.ForbiddenRegexp {
-		r, err := regexp.Compile(regxp)
+	var index *caseIndex
+
+	if cfg.CaseInsensitive {
+		index, err = newCaseIndex(overlay)
 		if err != nil {
-			slog.Error("Failed to compile firbidden regexp",
-				"regexp", regxp,
-				"error", err,
-			)
+			_ = overlay.Close()
+
+			return nil, errors.WithMessage(err, "failed to index game files")
 		}
 
-		forbiddenRegexps = append(forbiddenRegexps, r)
+		for _, names := range index.Collisions() {
+			slog.Warn("Files differing only in case found, see fastdl_case_collisions", "names", names)
+		}
 	}
 
-	return &fileHandler{
+	handler := &fileHandler{
 		baseDir: baseDir,
 		plugin:  plugin,
-		config:  plugin.cfg,
 
-		fileCache: NewMRUCache(plugin.cfg.CacheSize.Int64()),
+		fs:        overlay,
+		caseIndex: index,
+		encodings: encodings,
+		fileCache: NewMRUCache(cfg.CacheSize.Int64()),
 
-		allowedExtensions:   allowedExtensions,
-		forbiddenExtensions: forbiddenExtensions,
-		allowedPaths:        allowedPaths,
-		forbiddenPaths:      forbiddenPaths,
-		forbiddenRegexps:    forbiddenRegexps,
+		secretGuard: secretGuard,
 	}
+
+	handler.access.Store(&fileAccess{config: cfg, rules: rules})
+
+	return handler, nil
+}
+
+// Config returns the config the handler currently applies.
+func (h *fileHandler) Config() *Config {
+	return h.access.Load().config
+}
+
+// setAccess replaces the config and the access rules, e.g. with the overrides of a new map.
+func (h *fileHandler) setAccess(cfg *Config, rules *AccessRules) {
+	h.access.Store(&fileAccess{config: cfg, rules: rules})
 }
 
 func (h *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -80,63 +103,134 @@ func (h *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	requestedPath := filepath.Clean(r.URL.Path)
-	fullPath := filepath.Join(h.baseDir, requestedPath)
+	name := h.resolveRequest(r.URL.Path)
+	requestedPath := path.Clean("/" + name)
+
+	if cached, ok := h.fileCache.Get(name); ok {
+		if !h.checkDecision(w, r, requestedPath, h.fileDecision(requestedPath)) {
+			return
+		}
 
-	if h.fileCache.Exists(fullPath) {
 		// Serve cached file.
 
-		http.ServeFileFS(w, r, h.fileCache, fullPath)
+		http.ServeContent(w, r, cached.FileInfo.Name(), cached.FileInfo.ModTime(), bytes.NewReader(cached.Contents))
 
 		return
 	}
 
-	info, err := os.Stat(fullPath)
+	info, err := h.fs.Stat(name)
 	if err != nil {
-		http.NotFound(w, r)
+		h.checkDecision(w, r, requestedPath, accessDecision{Reason: "file not found"})
 
 		return
 	}
 
 	if info.IsDir() {
-		h.serveDirInfo(w, r, requestedPath, fullPath)
+		h.serveDirInfo(w, r, requestedPath, name)
 
 		return
 	}
 
-	if !h.fileAllowed(requestedPath) {
-		http.NotFound(w, r)
+	if !h.checkDecision(w, r, requestedPath, h.fileDecision(requestedPath)) {
+		return
+	}
+
+	contents, err := h.fs.ReadFile(name)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
 
 		return
 	}
 
-	if !h.fileCache.Exists(fullPath) {
-		contents, err := os.ReadFile(fullPath)
-		if err != nil {
-			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+	if pattern, found := h.secretGuard.Inspect(requestedPath, contents); found {
+		slog.Error("Refusing to serve file containing secrets",
+			"path", requestedPath,
+			"pattern", pattern,
+		)
 
-			return
+		h.checkDecision(w, r, requestedPath, accessDecision{Reason: "secret guard: " + pattern})
+
+		return
+	}
+
+	h.fileCache.Put(name, &CacheFile{
+		Contents: contents,
+		FileInfo: info,
+	})
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(contents))
+}
+
+// resolveRequest returns the name on disk for the request path.
+// Candidates decoding "+" and percent-encoded characters, and translations to the filename encodings
+// are used if the path itself does not exist.
+func (h *fileHandler) resolveRequest(urlPath string) string {
+	var candidates []string
+
+	for _, candidate := range requestNameCandidates(urlPath) {
+		candidates = append(candidates, h.encodings.Variants(candidate)...)
+	}
+
+	if len(candidates) == 1 {
+		return h.resolveName(candidates[0])
+	}
+
+	for _, candidate := range candidates {
+		name := h.resolveName(candidate)
+
+		if _, err := h.fs.Stat(name); err == nil {
+			return name
 		}
+	}
+
+	return candidates[0]
+}
+
+// resolveName returns the name on disk for the name in any case if case-insensitive resolution is enabled.
+// An exact match wins over other names differing only in case.
+func (h *fileHandler) resolveName(name string) string {
+	actual, ok := h.caseIndex.Lookup(name)
+	if !ok || actual == name {
+		return name
+	}
 
-		h.fileCache.Put(fullPath, &CacheFile{
-			Contents: contents,
-			FileInfo: info,
-		})
+	if _, err := h.fs.Stat(name); err == nil {
+		return name
 	}
 
-	http.ServeFile(w, r, fullPath)
+	return actual
 }
 
-func (h *fileHandler) serveDirInfo(w http.ResponseWriter, r *http.Request, requestedPath, fullPath string) {
-	if !h.config.AutoIndexEnabled {
+// checkDecision responds with 404 if the decision denies access.
+// In debug mode the decision is added to the response headers and logged.
+func (h *fileHandler) checkDecision(w http.ResponseWriter, r *http.Request, requestedPath string, decision accessDecision) bool {
+	if h.Config().Debug {
+		w.Header().Set(decisionHeader, decision.String())
+
+		slog.Info("Access decision",
+			"path", requestedPath,
+			"allowed", decision.Allowed,
+			"reason", decision.Reason,
+		)
+	}
+
+	if !decision.Allowed {
 		http.NotFound(w, r)
 
-		return
+		return false
 	}
 
-	if !h.pathAllowed(requestedPath) {
-		http.NotFound(w, r)
+	return true
+}
+
+func (h *fileHandler) serveDirInfo(w http.ResponseWriter, r *http.Request, requestedPath, name string) {
+	if !h.Config().AutoIndexEnabled && !clientMembershipFromContext(r.Context()).AutoIndex() {
+		h.checkDecision(w, r, requestedPath, accessDecision{Reason: "auto index is disabled"})
+
+		return
+	}
 
+	if !h.checkDecision(w, r, requestedPath, h.pathDecision(requestedPath)) {
 		return
 	}
 
@@ -146,7 +240,7 @@ func (h *fileHandler) serveDirInfo(w http.ResponseWriter, r *http.Request, reque
 		return
 	}
 
-	entries, err := os.ReadDir(fullPath)
+	entries, err := h.fs.ReadDir(name)
 	if err != nil {
 		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
 
@@ -160,12 +254,13 @@ func (h *fileHandler) serveDirInfo(w http.ResponseWriter, r *http.Request, reque
 	}
 
 	for _, entry := range entries {
-		entryPath := filepath.Join(requestedPath, entry.Name())
+		entryPath := path.Join(requestedPath, entry.Name())
+		entryName := h.encodings.DisplayName(entry.Name())
 
 		if entry.IsDir() && h.pathAllowed(entryPath) {
-			items = append(items, entry.Name()+"/")
+			items = append(items, entryName+"/")
 		} else if h.fileAllowed(entryPath) {
-			items = append(items, entry.Name())
+			items = append(items, entryName)
 		}
 	}
 
@@ -185,87 +280,83 @@ func (h *fileHandler) serveDirInfo(w http.ResponseWriter, r *http.Request, reque
 }
 
 func (h *fileHandler) fileAllowed(filePath string) bool {
+	return h.fileDecision(filePath).Allowed
+}
+
+func (h *fileHandler) pathAllowed(filePath string) bool {
+	return h.pathDecision(filePath).Allowed
+}
+
+func (h *fileHandler) fileDecision(filePath string) accessDecision {
 	filePath = strings.TrimPrefix(filePath, "/")
 
-	fileName := filepath.Base(filePath)
-	if strings.HasPrefix(fileName, ".") {
-		return false
+	decision := h.rulesDecision(filePath, false)
+	if !decision.Allowed {
+		return decision
 	}
 
 	ext := strings.ToLower(filepath.Ext(filePath))
 	ext = strings.TrimPrefix(ext, ".")
 
-	if h.config.ServePrecached && ext != "wad" {
-		if h.plugin.precachedFiles == nil {
-			return false
-		}
-
-		if _, ok := (*h.plugin.precachedFiles)[filePath]; !ok {
-			return false
+	if h.Config().ServePrecached && ext != "wad" {
+		if !h.isPrecached(filePath) {
+			return accessDecision{Reason: "servePrecached: file is not precached"}
 		}
 	}
 
-	if ext == "cfg" || ext == "ini" {
-		return false
-	}
+	return decision
+}
+
+// rulesDecision evaluates the access rules for the path in each of the filename encodings,
+// so rules written in UTF-8 apply to names stored in a legacy code page.
+// A deny rule matching any of the names wins, otherwise any allowed name allows the path.
+func (h *fileHandler) rulesDecision(filePath string, isDir bool) accessDecision {
+	rules := h.access.Load().rules
 
-	for _, r := range h.forbiddenRegexps {
-		if r.MatchString(fileName) {
-			return false
+	var decision accessDecision
+
+	for i, name := range h.encodings.Variants(filePath) {
+		allowed, rule := rules.Evaluate(name, isDir)
+		if !allowed && rule != nil {
+			return rulesDecision(allowed, rule)
 		}
-	}
 
-	if _, ok := h.forbiddenExtensions[ext]; ok {
-		return false
+		if i == 0 || allowed && !decision.Allowed {
+			decision = rulesDecision(allowed, rule)
+		}
 	}
 
-	if _, ok := h.allowedExtensions[ext]; ok {
-		return true
+	return decision
+}
+
+// isPrecached reports whether the path is precached in any of the filename encodings.
+func (h *fileHandler) isPrecached(filePath string) bool {
+	for _, name := range h.encodings.Variants(filePath) {
+		if h.plugin.IsPrecached(name) {
+			return true
+		}
 	}
 
-	// If no extensions are allowed, then all extensions are allowed
-	return len(h.allowedExtensions) == 0
+	return false
 }
 
-func (h *fileHandler) pathAllowed(filePath string) bool {
+func (h *fileHandler) pathDecision(filePath string) accessDecision {
 	if filePath == "/" {
-		return true
+		return accessDecision{Allowed: true, Reason: "root directory"}
 	}
 
 	filePath = strings.TrimPrefix(filePath, "/")
 
-	if strings.HasSuffix(filePath, "addons") {
-		return false
-	}
-
-	if h.config.ServePrecached {
-		if h.plugin.precachedFiles == nil {
-			return false
-		}
-
-		if _, ok := (*h.plugin.precachedFiles)[filePath]; !ok {
-			return false
-		}
+	decision := h.rulesDecision(filePath, true)
+	if !decision.Allowed {
+		return decision
 	}
 
-	for _, r := range h.forbiddenRegexps {
-		if r.MatchString(filePath) {
-			return false
-		}
-	}
-
-	for path := range h.forbiddenPaths {
-		if strings.HasPrefix(filePath, path) {
-			return false
-		}
-	}
-
-	for path := range h.allowedPaths {
-		if strings.HasPrefix(filePath, path) {
-			return true
+	if h.Config().ServePrecached {
+		if !h.isPrecached(filePath) {
+			return accessDecision{Reason: "servePrecached: directory has no precached files"}
 		}
 	}
 
-	// If no paths are allowed, then all paths are allowed
-	return len(h.allowedPaths) == 0
+	return decision
 }
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configSeverityError   = "error"
+	configSeverityWarning = "warning"
+)
+
+// ConfigIssue is a problem found in the config file. Errors prevent the plugin from starting.
+type ConfigIssue struct {
+	File     string
+	Line     int
+	Severity string
+	Message  string
+}
+
+func (i ConfigIssue) String() string {
+	location := i.File
+	if i.Line > 0 {
+		location = fmt.Sprintf("%s:%d", i.File, i.Line)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", location, i.Severity, i.Message)
+}
+
+type ConfigIssues []ConfigIssue
+
+func (issues ConfigIssues) Count(severity string) int {
+	n := 0
+
+	for _, issue := range issues {
+		if issue.Severity == severity {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (issues ConfigIssues) HasErrors() bool {
+	return issues.Count(configSeverityError) > 0
+}
+
+// configValidator collects issues, options are referenced by paths like "rateLimits[0].period".
+type configValidator struct {
+	file    string
+	gameDir string
+	lines   map[string]int
+	issues  ConfigIssues
+}
+
+var yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// ValidateConfig parses the config file on top of the base config and checks the values.
+// The config is nil if the file can not be parsed.
+func ValidateConfig(gameDir, file string, in []byte) (*Config, ConfigIssues) {
+	v := &configValidator{
+		file:    file,
+		gameDir: gameDir,
+		lines:   make(map[string]int),
+	}
+
+	var root yaml.Node
+
+	if err := yaml.Unmarshal(in, &root); err != nil {
+		v.addError(err)
+
+		return nil, v.issues
+	}
+
+	v.walk(&root, reflect.TypeOf(Config{}), "")
+
+	// The profile is applied before the file, so it is read from the file first.
+	var profile struct {
+		Profile string `yaml:"profile"`
+	}
+
+	_ = root.Decode(&profile)
+
+	if profile.Profile == "" {
+		profile.Profile = os.Getenv(envPrefix + envName("profile"))
+	}
+
+	base, envIssues := BaseConfig(gameDir, profile.Profile)
+	v.issues = append(envIssues, v.issues...)
+
+	cfg, err := ParseConfig(base, in)
+	if err != nil {
+		v.addError(err)
+
+		return nil, v.issues
+	}
+
+	v.check(cfg)
+
+	slices.SortStableFunc(v.issues, func(a, b ConfigIssue) int {
+		return a.Line - b.Line
+	})
+
+	return cfg, v.issues
+}
+
+func (v *configValidator) errorf(path, format string, args ...any) {
+	v.add(configSeverityError, v.line(path), path, fmt.Sprintf(format, args...))
+}
+
+func (v *configValidator) warnf(path, format string, args ...any) {
+	v.add(configSeverityWarning, v.line(path), path, fmt.Sprintf(format, args...))
+}
+
+func (v *configValidator) add(severity string, line int, path, message string) {
+	if path != "" {
+		message = path + ": " + message
+	}
+
+	v.issues = append(v.issues, ConfigIssue{
+		File:     v.file,
+		Line:     line,
+		Severity: severity,
+		Message:  message,
+	})
+}
+
+// addError adds decoding errors, their messages start with the line number.
+func (v *configValidator) addError(err error) {
+	var messages []string
+
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{errors.Cause(err).Error()}
+	}
+
+	for _, message := range messages {
+		message = strings.TrimPrefix(message, "yaml: ")
+
+		line := 0
+		if _, scanErr := fmt.Sscanf(message, "line %d:", &line); scanErr == nil {
+			_, message, _ = strings.Cut(message, ": ")
+		}
+
+		v.add(configSeverityError, line, "", message)
+	}
+}
+
+// line returns the line of the option or the closest parent option.
+func (v *configValidator) line(path string) int {
+	for path != "" {
+		if line, ok := v.lines[path]; ok {
+			return line
+		}
+
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+
+		path = path[:i]
+	}
+
+	return 0
+}
+
+// walk records lines of options and reports unknown keys.
+func (v *configValidator) walk(node *yaml.Node, t reflect.Type, path string) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			v.walk(child, t, path)
+		}
+
+		return
+	case yaml.AliasNode:
+		v.walk(node.Alias, t, path)
+
+		return
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(ConfigMaps{}) {
+		v.walk(node, reflect.TypeOf(map[string]ConfigMapOverride{}), path)
+
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+
+		fields := yamlFields(t)
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinConfigPath(path, key.Value)
+
+			v.lines[keyPath] = key.Line
+
+			field, ok := fields[key.Value]
+			if !ok {
+				v.warnf(keyPath, "unknown option, it is ignored")
+
+				continue
+			}
+
+			v.walk(value, field.Type, keyPath)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinConfigPath(path, key.Value)
+
+			v.lines[keyPath] = key.Line
+			v.walk(value, t.Elem(), keyPath)
+		}
+	case reflect.Slice:
+		// List operations (replace, append) of ConfigList.
+		if node.Kind == yaml.MappingNode && reflect.PointerTo(t).Implements(yamlUnmarshalerType) {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				keyPath := joinConfigPath(path, key.Value)
+
+				v.lines[keyPath] = key.Line
+				v.walk(value, t, keyPath)
+			}
+
+			return
+		}
+
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+
+		for i, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+
+			v.lines[itemPath] = item.Line
+			v.walk(item, t.Elem(), itemPath)
+		}
+	}
+}
+
+// yamlFields returns struct fields by their yaml keys.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(field.Name)
+		}
+
+		fields[name] = field
+	}
+
+	return fields
+}
+
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func (v *configValidator) check(cfg *Config) {
+	if _, _, err := cfg.PortRange.Parse(); err != nil {
+		v.errorf("portRange", "%s", err)
+	} else if cfg.PortRange != "" && cfg.Port != 0 {
+		v.warnf("portRange", "ignored because port is set")
+	}
+
+	if cfg.BindAddress != "" {
+		if _, err := netip.ParseAddr(cfg.BindAddress); err != nil {
+			v.errorf("bindAddress", "invalid IP address %q", cfg.BindAddress)
+		}
+	}
+
+	if strings.ContainsAny(cfg.PublicHost, "/ ") {
+		v.errorf("publicHost", "invalid host %q, expected an IP address or a host name without scheme and port", cfg.PublicHost)
+	}
+
+	if cfg.Host != "" {
+		v.warnf("host", "deprecated, use bindAddress and publicHost")
+	}
+
+	v.checkSize("cacheSize", cfg.CacheSize)
+	v.checkSize("exposureLargeFileSize", cfg.ExposureLargeFileSize)
+
+	v.checkDuration("http.readTimeout", cfg.HTTP.ReadTimeout)
+	v.checkDuration("http.writeTimeout", cfg.HTTP.WriteTimeout)
+	v.checkDuration("connectedClientsTTL", cfg.ConnectedClientsTTL)
+
+	if cfg.CustomDownloadURL != "" {
+		u, err := url.Parse(cfg.CustomDownloadURL)
+		if err != nil {
+			v.errorf("customDownloadURL", "invalid URL %q", cfg.CustomDownloadURL)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			v.warnf("customDownloadURL", "URL %q has no http:// or https:// scheme", cfg.CustomDownloadURL)
+		}
+	}
+
+	for i, rateLimit := range cfg.RateLimits {
+		itemPath := fmt.Sprintf("rateLimits[%d]", i)
+
+		v.checkDuration(itemPath+".period", rateLimit.Period)
+
+		if rateLimit.Period == "" {
+			v.errorf(itemPath, "period is not set")
+		}
+
+		if rateLimit.Limit <= 0 {
+			v.errorf(itemPath, "limit must be greater than 0")
+		}
+	}
+
+	v.checkRules(cfg)
+
+	for i, entry := range cfg.BlockListIP {
+		if strings.HasPrefix(entry, blockListFilePrefix) {
+			continue
+		}
+
+		if _, err := ParseIPRange(entry); err != nil {
+			v.errorf(fmt.Sprintf("blockListIP[%d]", i), "%s", err)
+		}
+	}
+
+	v.checkIntrusionDetection(cfg.IntrusionDetection)
+
+	for name, group := range cfg.ClientGroups {
+		for i, entry := range group.IPs {
+			if _, err := ParseIPRange(entry); err != nil {
+				v.errorf(fmt.Sprintf("clientGroups.%s.ips[%d]", name, i), "%s", err)
+			}
+		}
+	}
+
+	for i, name := range cfg.PrivateGroups {
+		if _, ok := cfg.ClientGroups[name]; !ok {
+			v.errorf(fmt.Sprintf("privateGroups[%d]", i), "unknown client group %q", name)
+		}
+	}
+
+	v.checkGeoIP(cfg.GeoIP)
+
+	for i, expr := range cfg.SecretGuard.Patterns {
+		if _, err := regexp.Compile(expr); err != nil {
+			v.errorf(fmt.Sprintf("secretGuard.patterns[%d]", i), "invalid regular expression: %s", err)
+		}
+	}
+
+	v.checkMaps(cfg.Maps)
+
+	for i, name := range cfg.FilenameEncodings {
+		if _, err := newFilenameEncodings([]string{name}); err != nil {
+			v.errorf(fmt.Sprintf("filenameEncodings[%d]", i), "%s", err)
+		}
+	}
+
+	switch cfg.Symlinks {
+	case "", symlinksDeny, symlinksAllowWithinGameDir, symlinksAllow:
+	default:
+		v.errorf("symlinks", "invalid policy %q, expected %q, %q or %q",
+			cfg.Symlinks,
+			symlinksDeny,
+			symlinksAllowWithinGameDir,
+			symlinksAllow,
+		)
+	}
+
+	for i, dir := range cfg.SearchPaths {
+		if dir == "" || dir == "." || dir == ".." || strings.ContainsAny(dir, `/\`) {
+			v.errorf(fmt.Sprintf("searchPaths[%d]", i), "invalid directory %q, expected a directory name next to the game directory", dir)
+		}
+	}
+}
+
+func (v *configValidator) checkDuration(path string, value ConfigTimeout) {
+	if _, err := value.Parse(); err != nil {
+		v.errorf(path, "%s", err)
+	}
+}
+
+func (v *configValidator) checkSize(path string, value ConfigCacheSize) {
+	if _, err := value.Parse(); err != nil {
+		v.errorf(path, "%s", err)
+	}
+}
+
+func (v *configValidator) checkRules(cfg *Config) {
+	failed := false
+
+	for i, expr := range cfg.ForbiddenRegexp {
+		if _, err := regexp.Compile(expr); err != nil {
+			v.errorf(fmt.Sprintf("forbiddenRegexp[%d]", i), "invalid regular expression: %s", err)
+
+			failed = true
+		}
+	}
+
+	for i, pattern := range cfg.Rules {
+		if _, err := newGlobRule(fmt.Sprintf("pattern %q", pattern), pattern); err != nil {
+			v.errorf(fmt.Sprintf("rules[%d]", i), "%s", err)
+
+			failed = true
+		}
+	}
+
+	for i, pattern := range cfg.BaselineOverrides {
+		itemPath := fmt.Sprintf("baselineOverrides[%d]", i)
+
+		r, err := newGlobRule(fmt.Sprintf("pattern %q", pattern), pattern)
+		if err != nil {
+			v.errorf(itemPath, "%s", err)
+
+			failed = true
+		} else if !r.allow {
+			v.errorf(itemPath, "baseline overrides can not deny")
+
+			failed = true
+		}
+	}
+
+	switch strings.ToLower(cfg.RulesMatch) {
+	case "", rulesMatchFirst, rulesMatchLast:
+	default:
+		v.errorf("rulesMatch", "invalid value %q, expected %q or %q", cfg.RulesMatch, rulesMatchFirst, rulesMatchLast)
+
+		failed = true
+	}
+
+	if failed {
+		return
+	}
+
+	// Everything else, e.g. patterns built from allowedPaths and allowedExtensions.
+	if _, err := NewAccessRules(cfg); err != nil {
+		v.errorf("", "%s", err)
+	}
+}
+
+func (v *configValidator) checkIntrusionDetection(cfg ConfigIntrusionDetection) {
+	v.checkDuration("intrusionDetection.window", cfg.Window)
+
+	for i, duration := range cfg.BanDurations {
+		v.checkDuration(fmt.Sprintf("intrusionDetection.banDurations[%d]", i), duration)
+	}
+
+	if cfg.Threshold < 0 {
+		v.errorf("intrusionDetection.threshold", "must not be negative")
+	}
+}
+
+func (v *configValidator) checkGeoIP(cfg ConfigGeoIP) {
+	switch strings.ToLower(cfg.DefaultAction) {
+	case "", geoIPActionAllow, geoIPActionDeny:
+	default:
+		v.errorf("geoIP.defaultAction", "invalid action %q, expected %q or %q",
+			cfg.DefaultAction,
+			geoIPActionAllow,
+			geoIPActionDeny,
+		)
+	}
+
+	for i, rule := range cfg.Rules {
+		itemPath := fmt.Sprintf("geoIP.rules[%d]", i)
+
+		if _, err := rule.Period.Parse(); err != nil {
+			v.errorf(itemPath+".period", "%s", err)
+
+			continue
+		}
+
+		if _, err := newGeoIPRule(rule); err != nil {
+			v.errorf(itemPath, "%s", err)
+		}
+	}
+
+	if len(cfg.Databases) == 0 && len(cfg.Rules) > 0 {
+		v.warnf("geoIP.rules", "ignored because no databases are set")
+	}
+
+	for i, database := range cfg.Databases {
+		if err := checkGeoIPDatabase(v.gameDir, database); err != nil {
+			v.errorf(fmt.Sprintf("geoIP.databases[%d]", i), "failed to open database %q: %s", database, err)
+		}
+	}
+}
+
+func (v *configValidator) checkMaps(maps ConfigMaps) {
+	for _, section := range maps {
+		sectionPath := joinConfigPath("maps", section.Pattern)
+
+		if _, err := path.Match(section.Pattern, ""); err != nil {
+			v.errorf(sectionPath, "invalid map name pattern %q", section.Pattern)
+		}
+
+		override, err := section.Override()
+		if err != nil {
+			continue
+		}
+
+		for i, pattern := range override.Rules {
+			if _, err := newGlobRule(fmt.Sprintf("pattern %q", pattern), pattern); err != nil {
+				v.errorf(fmt.Sprintf("%s.rules[%d]", sectionPath, i), "%s", err)
+			}
+		}
+	}
+}